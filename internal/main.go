@@ -15,6 +15,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -22,14 +23,10 @@ import (
 	"strings"
 
 	"github.com/clarketm/json"
-	"github.com/coreos/ignition/config/v2_4"
-	"github.com/coreos/ignition/v2/config/shared/errors"
-	"github.com/coreos/ignition/v2/config/v3_0"
-	"github.com/coreos/ignition/v2/config/v3_1"
-	translateTo3_1 "github.com/coreos/ignition/v2/config/v3_1/translate"
-
-	"github.com/coreos/ign-converter/translate/v24tov31"
-	"github.com/coreos/ign-converter/translate/v31tov24"
+	"github.com/coreos/go-semver/semver"
+
+	"github.com/coreos/ign-converter/translate"
+	"github.com/coreos/ign-converter/util"
 )
 
 func fail(format string, args ...interface{}) {
@@ -38,39 +35,86 @@ func fail(format string, args ...interface{}) {
 }
 
 func getMapping(fname string) map[string]string {
-	m := map[string]string{}
 	if fname == "" {
-		return m
+		return map[string]string{}
 	}
-	data, err := os.ReadFile(fname)
+	m, warnings, err := util.ReadFsMap(fname)
 	if err != nil {
-		fail("Error reading %s: %v", fname, err)
-	}
-	// parse
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, " ")
-		if len(parts) != 2 {
-			fail("Error parsing line: %q, needs two parts", line)
-		}
-		m[parts[0]] = parts[1]
+		fail("Error reading filesystem mapping: %v", err)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", w)
 	}
 	return m
 }
 
+func getPolicy(fname string) util.Policy {
+	if fname == "" {
+		return nil
+	}
+	p, err := util.ReadPolicy(fname)
+	if err != nil {
+		fail("Error reading policy: %v", err)
+	}
+	return p
+}
+
+func writeMapping(fname string, m map[string]string) {
+	if err := os.WriteFile(fname, util.FormatFsMap(m), 0644); err != nil {
+		fail("Error writing %s: %v", fname, err)
+	}
+}
+
+func parseVersion(s string) (semver.Version, error) {
+	// allow the patch version to be omitted, e.g. "3.4"
+	if strings.Count(s, ".") == 1 {
+		s += ".0"
+	}
+	v, err := semver.NewVersion(s)
+	if err != nil {
+		return semver.Version{}, err
+	}
+	return *v, nil
+}
+
 func main() {
 	var (
 		input         string
 		output        string
 		fsMap         string
+		fsMapOut      string
+		inferFsMap    bool
+		networkd      bool
+		filesNetworkd bool
+		migrate       bool
+		dedupe        bool
+		resolveLinks  bool
+		dropUnsup     bool
+		policy        string
+		sectorSize    int
+		targetVersion string
 		versionFlag   bool
-		downtranslate bool
 	)
+	var supported []string
+	for _, v := range translate.Versions() {
+		supported = append(supported, v.String())
+	}
 	flag.BoolVar(&versionFlag, "version", false, "print the version and exit")
 	flag.StringVar(&input, "input", "", "read from input file instead of stdin")
-	flag.StringVar(&fsMap, "fsmap", "", "file containing mapping from filesystem name to path")
+	flag.StringVar(&fsMap, "fsmap", "", "file containing mapping from filesystem name to path, as plain text, JSON or YAML")
+	flag.StringVar(&fsMapOut, "fsmap-out", "", "write the filesystem mapping generated when translating from spec 3 to spec 2 to this file, in the format read by -fsmap")
+	flag.BoolVar(&inferFsMap, "infer-fsmap", false, "infer the paths of filesystems missing from -fsmap from mount units and filesystem labels")
+	flag.BoolVar(&networkd, "networkd-to-files", false, "write v2 networkd units to files in /etc/systemd/network when translating to spec 3")
+	flag.BoolVar(&filesNetworkd, "files-to-networkd", false, "move inline files in /etc/systemd/network to the networkd section when translating to spec 2")
+	flag.BoolVar(&migrate, "migrate-deprecated", false, "rewrite fields deprecated in spec 2 to their replacements when translating to spec 3")
+	flag.BoolVar(&dedupe, "remove-duplicates", false, "remove duplicated entries from spec 2 configs when translating to spec 3, keeping the latest")
+	flag.BoolVar(&resolveLinks, "resolve-links", false, "rewrite spec 2 paths that go through links created by the config to the link targets when translating to spec 3")
+	flag.BoolVar(&dropUnsup, "drop-unsupported", false, "drop the fields the target spec version cannot represent when translating to an older spec version, instead of failing")
+	flag.StringVar(&policy, "policy", "", "JSON or YAML file mapping features to fail, drop or workaround, deciding what happens to them when translating to an older spec version")
+	flag.BoolVar(&util.DebugCoverage, "debug-coverage", false, "fail translations to an older spec version that lose a field without reporting it, for debugging the translators")
+	flag.IntVar(&sectorSize, "sector-size", 512, "logical sector size in bytes used to convert partition sizes between sectors and MiB")
 	flag.StringVar(&output, "output", "", "write to output file instead of stdout")
-	flag.BoolVar(&downtranslate, "downtranslate", false, "translate a spec 3 config down to spec 2")
+	flag.StringVar(&targetVersion, "target-version", "", "spec version to translate to, one of: "+strings.Join(supported, ", "))
 
 	flag.Parse()
 
@@ -79,6 +123,14 @@ func main() {
 		os.Exit(0)
 	}
 
+	if targetVersion == "" {
+		fail("--target-version is required")
+	}
+	target, err := parseVersion(targetVersion)
+	if err != nil {
+		fail("Invalid target version %q: %v", targetVersion, err)
+	}
+
 	var infile *os.File = os.Stdin
 	var outfile *os.File = os.Stdout
 	if input != "" {
@@ -95,52 +147,115 @@ func main() {
 		fail("failed to read %s: %v", infile.Name(), err)
 	}
 
-	var dataOut []byte
-	if downtranslate {
-		// translate from 3.x to 2.4
-		cfg, rpt, err := v3_1.Parse(dataIn)
-		fmt.Fprintf(os.Stderr, "%s", rpt.String())
-		if err == errors.ErrUnknownVersion {
-			// We can fail unmarshaling if it's an older config. Attempt to parse
-			// it as such.
-			cfg3_0, rpt, err := v3_0.Parse(dataIn)
+	// parse, auto-detecting the spec version
+	cfg, rpt, err := translate.Parse(dataIn)
+	if err != nil {
+		if rpt != nil && rpt.IsFatal() {
+			// the report already describes the problem
 			fmt.Fprintf(os.Stderr, "%s", rpt.String())
-			if err != nil || rpt.IsFatal() {
-				fail("Error parsing spec v3.0 config: %v\n%v", err, rpt)
-			}
-			cfg = translateTo3_1.Translate(cfg3_0)
-		} else if err != nil || rpt.IsFatal() {
-			fail("Error parsing spec v3.1 config: %v\n%v", err, rpt)
+			fail("Error parsing config")
 		}
-
-		newCfg, err := v31tov24.Translate(cfg)
-		if err != nil {
-			fail("Failed to translate config from 3 to 2: %v", err)
-		}
-		dataOut, err = json.Marshal(newCfg)
-		if err != nil {
-			fail("Failed to marshal json: %v", err)
-		}
-	} else {
-		// translate from 2.x to 3.1
-		mapping := getMapping(fsMap)
-
-		// parse
-		cfg, rpt, err := v2_4.Parse(dataIn)
+		fail("Error parsing config: %v", err)
+	}
+	if rpt != nil {
 		fmt.Fprintf(os.Stderr, "%s", rpt.String())
-		if err != nil || rpt.IsFatal() {
-			fail("Error parsing spec v2 config: %v\n%v", err, rpt)
+	}
+
+	// fail for flags that no step of the translation would use
+	from, err := translate.DetectVersion(dataIn)
+	if err != nil {
+		fail("Error parsing config: %v", err)
+	}
+	path, err := translate.FindPath(from, target)
+	if err != nil {
+		fail("Failed to translate config to %s: %v", target, err)
+	}
+	var fromV1, up, down, older, to2_2 bool
+	for i := 1; i < len(path); i++ {
+		prev, next := path[i-1], path[i]
+		fromV1 = fromV1 || prev.Major == 1
+		up = up || prev.Major == 2 && next.Major == 3
+		down = down || prev.Major == 3 && next.Major == 2
+		older = older || next.LessThan(prev)
+		to2_2 = to2_2 || prev.Major == 3 && next.Major == 2 && next.Minor == 2
+	}
+	sectorSizeSet := false
+	flag.Visit(func(f *flag.Flag) {
+		sectorSizeSet = sectorSizeSet || f.Name == "sector-size"
+	})
+	if dropUnsup && policy != "" {
+		fail("--drop-unsupported and --policy cannot be used together")
+	}
+	for _, f := range []struct {
+		name string
+		set  bool
+		used bool
+		step string
+	}{
+		{"fsmap", fsMap != "", fromV1 || up, "from spec 1 or 2 to spec 3"},
+		{"infer-fsmap", inferFsMap, up, "from spec 2 to spec 3"},
+		{"networkd-to-files", networkd, up, "from spec 2 to spec 3"},
+		{"migrate-deprecated", migrate, up, "from spec 2 to spec 3"},
+		{"remove-duplicates", dedupe, up, "from spec 2 to spec 3"},
+		{"resolve-links", resolveLinks, up, "from spec 2 to spec 3"},
+		{"files-to-networkd", filesNetworkd, down, "from spec 3 to spec 2"},
+		{"fsmap-out", fsMapOut != "", down, "from spec 3 to spec 2"},
+		{"sector-size", sectorSizeSet, fromV1 || up && migrate || to2_2, "from spec 1 to spec 3, from spec 2 to spec 3 with --migrate-deprecated, or from spec 3 to spec 2.2"},
+		{"drop-unsupported", dropUnsup, older, "to an older spec version"},
+		{"policy", policy != "", older, "to an older spec version"},
+	} {
+		if f.set && !f.used {
+			fail("--%s only applies when translating %s, which translating spec %s to %s does not do", f.name, f.step, from, target)
 		}
+	}
 
-		newCfg, err := v24tov31.Translate(cfg, mapping)
-		if err != nil {
-			fail("Failed to translate config from 2 to 3: %v", err)
+	newCfg, res, err := translate.TranslateConfig(cfg, target, translate.Options{
+		FsMap:             getMapping(fsMap),
+		InferFsMap:        inferFsMap,
+		NetworkdToFiles:   networkd,
+		NetworkdFromFiles: filesNetworkd,
+		MigrateDeprecated: migrate,
+		RemoveDuplicates:  dedupe,
+		ResolveLinks:      resolveLinks,
+		DropUnsupported:   dropUnsup,
+		Policy:            getPolicy(policy),
+		SectorSize:        sectorSize,
+	})
+	if err != nil {
+		var errs util.Errors
+		if errors.As(err, &errs) {
+			fmt.Fprintf(os.Stderr, "Failed to translate config to %s, found %d problems:\n", target, len(errs))
+			for _, e := range errs {
+				fmt.Fprintf(os.Stderr, "  %v\n", e)
+			}
+			os.Exit(1)
 		}
-		dataOut, err = json.Marshal(newCfg)
-		if err != nil {
-			fail("Failed to marshal json: %v", err)
+		fail("Failed to translate config to %s: %v", target, err)
+	}
+	var steps []string
+	for _, v := range res.Path {
+		steps = append(steps, v.String())
+	}
+	fmt.Fprintf(os.Stderr, "Translated config: %s\n", strings.Join(steps, " -> "))
+	for _, r := range res.Rewrites {
+		fmt.Fprintf(os.Stderr, "Rewrote %s\n", r)
+	}
+	for _, d := range res.Degraded {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", d)
+	}
+	for _, p := range res.InferredFsMap {
+		if p.Source != util.FsMapSourceGiven {
+			fmt.Fprintf(os.Stderr, "Inferred path %s for filesystem %q from %s %q\n", p.Path, p.Filesystem, p.Source, p.Detail)
 		}
 	}
+	if fsMapOut != "" {
+		writeMapping(fsMapOut, res.FsMap)
+	}
+
+	dataOut, err := json.Marshal(newCfg)
+	if err != nil {
+		fail("Failed to marshal json: %v", err)
+	}
 	dataOut = append(dataOut, '\n')
 
 	if output != "" {
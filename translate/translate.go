@@ -0,0 +1,593 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package translate detects the spec version of a raw Ignition config and
+// chains the single-hop translators under this directory (along with
+// Ignition's own upward v3_x/translate packages) to reach any requested
+// spec version.
+package translate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/coreos/go-semver/semver"
+	"github.com/coreos/ignition/config/v1"
+	types1 "github.com/coreos/ignition/config/v1/types"
+	"github.com/coreos/ignition/config/v2_0"
+	types2_0 "github.com/coreos/ignition/config/v2_0/types"
+	"github.com/coreos/ignition/config/v2_1"
+	types2_1 "github.com/coreos/ignition/config/v2_1/types"
+	"github.com/coreos/ignition/config/v2_2"
+	types2_2 "github.com/coreos/ignition/config/v2_2/types"
+	"github.com/coreos/ignition/config/v2_3"
+	types2_3 "github.com/coreos/ignition/config/v2_3/types"
+	"github.com/coreos/ignition/config/v2_4"
+	types2_4 "github.com/coreos/ignition/config/v2_4/types"
+	"github.com/coreos/ignition/v2/config/v3_0"
+	types3_0 "github.com/coreos/ignition/v2/config/v3_0/types"
+	"github.com/coreos/ignition/v2/config/v3_1"
+	translateTo3_1 "github.com/coreos/ignition/v2/config/v3_1/translate"
+	types3_1 "github.com/coreos/ignition/v2/config/v3_1/types"
+	"github.com/coreos/ignition/v2/config/v3_2"
+	translateTo3_2 "github.com/coreos/ignition/v2/config/v3_2/translate"
+	types3_2 "github.com/coreos/ignition/v2/config/v3_2/types"
+	"github.com/coreos/ignition/v2/config/v3_3"
+	translateTo3_3 "github.com/coreos/ignition/v2/config/v3_3/translate"
+	types3_3 "github.com/coreos/ignition/v2/config/v3_3/types"
+	"github.com/coreos/ignition/v2/config/v3_4"
+	translateTo3_4 "github.com/coreos/ignition/v2/config/v3_4/translate"
+	types3_4 "github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/coreos/ignition/v2/config/v3_5"
+	translateTo3_5 "github.com/coreos/ignition/v2/config/v3_5/translate"
+	types3_5 "github.com/coreos/ignition/v2/config/v3_5/types"
+
+	"github.com/coreos/ign-converter/translate/v1tov30"
+	"github.com/coreos/ign-converter/translate/v23tov30"
+	"github.com/coreos/ign-converter/translate/v24tov31"
+	"github.com/coreos/ign-converter/translate/v30tov22"
+	"github.com/coreos/ign-converter/translate/v31tov22"
+	"github.com/coreos/ign-converter/translate/v31tov24"
+	"github.com/coreos/ign-converter/translate/v31tov30"
+	"github.com/coreos/ign-converter/translate/v32tov22"
+	"github.com/coreos/ign-converter/translate/v32tov24"
+	"github.com/coreos/ign-converter/translate/v32tov31"
+	"github.com/coreos/ign-converter/translate/v33tov24"
+	"github.com/coreos/ign-converter/translate/v33tov32"
+	"github.com/coreos/ign-converter/translate/v34tov24"
+	"github.com/coreos/ign-converter/translate/v34tov33"
+	"github.com/coreos/ign-converter/translate/v35tov24"
+	"github.com/coreos/ign-converter/translate/v35tov34"
+	"github.com/coreos/ign-converter/util"
+)
+
+// Options holds the extra information some translation steps need.
+type Options struct {
+	// FsMap is a map from v2 filesystem names to the paths under which they
+	// should be mounted in v3. It is only used when a spec 1 or 2 config is
+	// translated to spec 3. Spec 1 filesystems have no name and are looked
+	// up by device instead.
+	FsMap map[string]string
+	// InferFsMap fills in the paths of v2 filesystems missing from FsMap
+	// from the mount units and filesystem labels in the config. The source
+	// of every path is reported in Result.InferredFsMap.
+	InferFsMap bool
+	// NetworkdToFiles writes the units of a v2 networkd section to files in
+	// /etc/systemd/network instead of failing the translation to spec 3.
+	NetworkdToFiles bool
+	// NetworkdFromFiles moves inline files in /etc/systemd/network to the
+	// networkd section when translating from spec 3 to spec 2.
+	NetworkdFromFiles bool
+	// MigrateDeprecated rewrites the fields deprecated in spec 2.3 and 2.4
+	// to their replacements before translating to spec 3, instead of
+	// failing. The rewrites are reported in Result.Rewrites.
+	MigrateDeprecated bool
+	// RemoveDuplicates removes duplicated entries from spec 2 configs before
+	// translating them to spec 3, keeping the latest one. The discarded
+	// entries are reported in Result.Rewrites.
+	RemoveDuplicates bool
+	// ResolveLinks rewrites the paths of spec 2 files, directories and
+	// links that go through symlinks created by the same config to the
+	// locations the symlinks point to before translating to spec 3. The
+	// rewrites are reported in Result.Rewrites.
+	ResolveLinks bool
+	// DropUnsupported removes the fields that the target spec version
+	// cannot represent when translating to an older spec version, instead
+	// of failing. The removed fields are reported in Result.Degraded.
+	DropUnsupported bool
+	// Policy decides per feature what happens to the fields that the
+	// target spec version cannot represent when translating to an older
+	// spec version. Features missing from it fail the translation, or are
+	// dropped if DropUnsupported is set. The fields dropped or worked
+	// around are reported in Result.Degraded.
+	Policy util.Policy
+	// SectorSize is the logical sector size in bytes used to convert
+	// partition dimensions between sectors and MiB, both when migrating
+	// deprecated fields and when translating to spec 2.2. It must be 512,
+	// 4096, or 0 for 512.
+	SectorSize int
+}
+
+// Result describes how a config was translated.
+type Result struct {
+	// Path lists the spec versions the config went through, starting
+	// with the input version and ending with the target version.
+	Path []semver.Version
+	// FsMap maps the v2 filesystem names generated when translating from
+	// spec 3 to spec 2 to the paths the filesystems are mounted at. It can
+	// be passed back as Options.FsMap to translate the result to spec 3
+	// again. It is nil if no such translation took place.
+	FsMap map[string]string
+	// InferredFsMap lists the path of every filesystem and where it came
+	// from when Options.InferFsMap is set.
+	InferredFsMap []util.InferredPath
+	// Rewrites lists the changes made to the config when
+	// Options.MigrateDeprecated, Options.RemoveDuplicates or
+	// Options.ResolveLinks is set.
+	Rewrites []util.Rewrite
+	// Degraded lists the fields removed or worked around as
+	// Options.DropUnsupported and Options.Policy say. Each path refers to
+	// the config given to the translator that handled the field.
+	Degraded []util.Degradation
+}
+
+// policy returns the policy for translating to older spec versions.
+func (opts Options) policy() util.Policy {
+	if !opts.DropUnsupported {
+		return opts.Policy
+	}
+	p := util.DropPolicy()
+	for f, a := range opts.Policy {
+		p[f] = a
+	}
+	return p
+}
+
+// Report is the parse report of a spec 2 or spec 3 config.
+type Report interface {
+	String() string
+	IsFatal() bool
+}
+
+// spec describes a config version that can be parsed and/or produced.
+type spec struct {
+	version semver.Version
+	typ     reflect.Type
+	// parse is nil for versions that can only be produced
+	parse func([]byte) (interface{}, Report, error)
+}
+
+// translator is a single edge in the translation graph.
+type translator struct {
+	from      semver.Version
+	to        semver.Version
+	translate func(cfg interface{}, opts Options, res *Result) (interface{}, error)
+}
+
+var specs = []spec{
+	{types1.MaxVersion, reflect.TypeOf(types1.Config{}), func(raw []byte) (interface{}, Report, error) { return v1.Parse(raw) }},
+	{types2_0.MaxVersion, reflect.TypeOf(types2_0.Config{}), func(raw []byte) (interface{}, Report, error) { return v2_0.Parse(raw) }},
+	{types2_1.MaxVersion, reflect.TypeOf(types2_1.Config{}), func(raw []byte) (interface{}, Report, error) { return v2_1.Parse(raw) }},
+	{types2_2.MaxVersion, reflect.TypeOf(types2_2.Config{}), func(raw []byte) (interface{}, Report, error) { return v2_2.Parse(raw) }},
+	{types2_3.MaxVersion, reflect.TypeOf(types2_3.Config{}), func(raw []byte) (interface{}, Report, error) { return v2_3.Parse(raw) }},
+	{types2_4.MaxVersion, reflect.TypeOf(types2_4.Config{}), func(raw []byte) (interface{}, Report, error) { return v2_4.Parse(raw) }},
+	{types3_0.MaxVersion, reflect.TypeOf(types3_0.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_0.Parse(raw) }},
+	{types3_1.MaxVersion, reflect.TypeOf(types3_1.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_1.Parse(raw) }},
+	{types3_2.MaxVersion, reflect.TypeOf(types3_2.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_2.Parse(raw) }},
+	{types3_3.MaxVersion, reflect.TypeOf(types3_3.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_3.Parse(raw) }},
+	{types3_4.MaxVersion, reflect.TypeOf(types3_4.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_4.Parse(raw) }},
+	{types3_5.MaxVersion, reflect.TypeOf(types3_5.Config{}), func(raw []byte) (interface{}, Report, error) { return v3_5.Parse(raw) }},
+}
+
+// translators is the translation graph. When several paths of the same
+// length exist, the edge registered first wins.
+var translators = []translator{
+	// spec 1 -> spec 2 or 3
+	{types1.MaxVersion, types2_0.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return v2_0.TranslateFromV1(cfg.(types1.Config)), nil
+	}},
+	{types1.MaxVersion, types3_0.MaxVersion, func(cfg interface{}, opts Options, _ *Result) (interface{}, error) {
+		return v1tov30.TranslateWithSectorSize(cfg.(types1.Config), opts.FsMap, opts.SectorSize)
+	}},
+
+	// spec 2 -> spec 2, upward
+	{types2_0.MaxVersion, types2_1.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return v2_1.TranslateFromV2_0(cfg.(types2_0.Config)), nil
+	}},
+	{types2_1.MaxVersion, types2_2.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return v2_2.TranslateFromV2_1(cfg.(types2_1.Config)), nil
+	}},
+	{types2_2.MaxVersion, types2_3.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return v2_3.Translate(cfg.(types2_2.Config)), nil
+	}},
+	{types2_3.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return v2_4.Translate(cfg.(types2_3.Config)), nil
+	}},
+
+	// spec 2 -> spec 3
+	{types2_3.MaxVersion, types3_0.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		in := cfg.(types2_3.Config)
+		if opts.MigrateDeprecated {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v23tov30.MigrateDeprecated(in, opts.SectorSize); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+		}
+		fsMap := opts.FsMap
+		if opts.InferFsMap {
+			fsMap, res.InferredFsMap = v23tov30.InferFsMap(in, fsMap)
+		}
+		if opts.NetworkdToFiles {
+			var err error
+			if in, err = v23tov30.TranslateNetworkd(in, fsMap); err != nil {
+				return nil, err
+			}
+		}
+		if opts.ResolveLinks {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v23tov30.ResolveLinks(in, fsMap); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+		}
+		if opts.RemoveDuplicates {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v23tov30.RemoveDuplicates(in, fsMap); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+			// RemoveDuplicates leaves appends to a file after it
+			ret, rewrites, err := v23tov30.TranslateFoldingAppends(in, fsMap)
+			res.Rewrites = append(res.Rewrites, rewrites...)
+			return ret, err
+		}
+		return v23tov30.Translate(in, fsMap)
+	}},
+	{types2_4.MaxVersion, types3_1.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		in := cfg.(types2_4.Config)
+		if opts.MigrateDeprecated {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v24tov31.MigrateDeprecated(in, opts.SectorSize); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+		}
+		fsMap := opts.FsMap
+		if opts.InferFsMap {
+			fsMap, res.InferredFsMap = v24tov31.InferFsMap(in, fsMap)
+		}
+		if opts.NetworkdToFiles {
+			var err error
+			if in, err = v24tov31.TranslateNetworkd(in, fsMap); err != nil {
+				return nil, err
+			}
+		}
+		if opts.ResolveLinks {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v24tov31.ResolveLinks(in, fsMap); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+		}
+		if opts.RemoveDuplicates {
+			var rewrites []util.Rewrite
+			var err error
+			if in, rewrites, err = v24tov31.RemoveDuplicates(in, fsMap); err != nil {
+				return nil, err
+			}
+			res.Rewrites = append(res.Rewrites, rewrites...)
+			// RemoveDuplicates leaves appends to a file after it
+			ret, rewrites, err := v24tov31.TranslateFoldingAppends(in, fsMap)
+			res.Rewrites = append(res.Rewrites, rewrites...)
+			return ret, err
+		}
+		return v24tov31.Translate(in, fsMap)
+	}},
+
+	// spec 3 -> spec 3, upward
+	{types3_0.MaxVersion, types3_1.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return translateTo3_1.Translate(cfg.(types3_0.Config)), nil
+	}},
+	{types3_1.MaxVersion, types3_2.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return translateTo3_2.Translate(cfg.(types3_1.Config)), nil
+	}},
+	{types3_2.MaxVersion, types3_3.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return translateTo3_3.Translate(cfg.(types3_2.Config)), nil
+	}},
+	{types3_3.MaxVersion, types3_4.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return translateTo3_4.Translate(cfg.(types3_3.Config)), nil
+	}},
+	{types3_4.MaxVersion, types3_5.MaxVersion, func(cfg interface{}, _ Options, _ *Result) (interface{}, error) {
+		return translateTo3_5.Translate(cfg.(types3_4.Config)), nil
+	}},
+
+	// spec 3 -> spec 3, downward
+	{types3_5.MaxVersion, types3_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, degraded, err := v35tov34.TranslateWithPolicy(cfg.(types3_5.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		return ret, err
+	}},
+	{types3_4.MaxVersion, types3_3.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, degraded, err := v34tov33.TranslateWithPolicy(cfg.(types3_4.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		return ret, err
+	}},
+	{types3_3.MaxVersion, types3_2.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, degraded, err := v33tov32.TranslateWithPolicy(cfg.(types3_3.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		return ret, err
+	}},
+	{types3_2.MaxVersion, types3_1.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, degraded, err := v32tov31.TranslateWithPolicy(cfg.(types3_2.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		return ret, err
+	}},
+	{types3_1.MaxVersion, types3_0.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, degraded, err := v31tov30.TranslateWithPolicy(cfg.(types3_1.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		return ret, err
+	}},
+
+	// spec 3 -> spec 2
+	{types3_5.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v35tov24.TranslateWithPolicy(cfg.(types3_5.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v32tov24.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_4.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v34tov24.TranslateWithPolicy(cfg.(types3_4.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v32tov24.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_3.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v33tov24.TranslateWithPolicy(cfg.(types3_3.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v32tov24.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_2.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v32tov24.TranslateWithPolicy(cfg.(types3_2.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v32tov24.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_2.MaxVersion, types2_2.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v32tov22.TranslateWithPolicy(cfg.(types3_2.Config), opts.SectorSize, opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v32tov22.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_1.MaxVersion, types2_4.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v31tov24.TranslateWithPolicy(cfg.(types3_1.Config), opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v31tov24.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_1.MaxVersion, types2_2.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v31tov22.TranslateWithPolicy(cfg.(types3_1.Config), opts.SectorSize, opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v31tov22.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+	{types3_0.MaxVersion, types2_2.MaxVersion, func(cfg interface{}, opts Options, res *Result) (interface{}, error) {
+		ret, fsMap, degraded, err := v30tov22.TranslateWithPolicy(cfg.(types3_0.Config), opts.SectorSize, opts.policy())
+		res.Degraded = append(res.Degraded, degraded...)
+		res.FsMap = fsMap
+		if err == nil && opts.NetworkdFromFiles {
+			ret = v30tov22.FilesToNetworkd(ret)
+		}
+		return ret, err
+	}},
+}
+
+type versionStub struct {
+	Ignition struct {
+		Version string `json:"version"`
+	} `json:"ignition"`
+	// spec 1 configs use a top-level integer instead
+	IgnitionVersion *int `json:"ignitionVersion"`
+}
+
+// DetectVersion returns the spec version declared by the raw config in
+// `ignition.version` (or `ignitionVersion` for spec 1 configs).
+func DetectVersion(raw []byte) (semver.Version, error) {
+	var stub versionStub
+	if err := json.Unmarshal(raw, &stub); err != nil {
+		return semver.Version{}, fmt.Errorf("failed to parse config: %w", err)
+	}
+	if stub.Ignition.Version == "" && stub.IgnitionVersion != nil {
+		return semver.Version{Major: int64(*stub.IgnitionVersion)}, nil
+	}
+	if stub.Ignition.Version == "" {
+		return semver.Version{}, errors.New("config does not specify ignition.version")
+	}
+	v, err := semver.NewVersion(stub.Ignition.Version)
+	if err != nil {
+		return semver.Version{}, fmt.Errorf("invalid ignition.version %q: %w", stub.Ignition.Version, err)
+	}
+	return *v, nil
+}
+
+// Parse detects the spec version of the raw config and parses it with the
+// matching Ignition config package. The returned config is the types.Config
+// of that spec version.
+func Parse(raw []byte) (interface{}, Report, error) {
+	v, err := DetectVersion(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := findSpec(v)
+	if s == nil || s.parse == nil {
+		return nil, nil, fmt.Errorf("unsupported input spec version %s", v)
+	}
+	cfg, rpt, err := s.parse(raw)
+	if err != nil {
+		return nil, rpt, err
+	}
+	if rpt.IsFatal() {
+		return nil, rpt, fmt.Errorf("invalid spec %s config:\n%s", v, rpt.String())
+	}
+	return cfg, rpt, nil
+}
+
+// Translate parses the raw config and translates it to the target spec
+// version.
+func Translate(raw []byte, target semver.Version, opts Options) (interface{}, Result, error) {
+	cfg, _, err := Parse(raw)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	return TranslateConfig(cfg, target, opts)
+}
+
+// TranslateConfig translates an already parsed config (the types.Config of
+// any supported spec version) to the target spec version.
+func TranslateConfig(cfg interface{}, target semver.Version, opts Options) (interface{}, Result, error) {
+	from, err := configVersion(cfg)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	edges, err := findPath(from, target)
+	if err != nil {
+		return nil, Result{}, err
+	}
+	res := Result{
+		Path: []semver.Version{from},
+	}
+	for _, e := range edges {
+		cfg, err = e.translate(cfg, opts, &res)
+		if err != nil {
+			return nil, res, fmt.Errorf("translating spec %s to %s: %w", e.from, e.to, err)
+		}
+		res.Path = append(res.Path, e.to)
+	}
+	return cfg, res, nil
+}
+
+// FindPath returns the sequence of versions a config of version from would go
+// through to be translated to version to, including both ends.
+func FindPath(from, to semver.Version) ([]semver.Version, error) {
+	edges, err := findPath(from, to)
+	if err != nil {
+		return nil, err
+	}
+	path := []semver.Version{from}
+	for _, e := range edges {
+		path = append(path, e.to)
+	}
+	return path, nil
+}
+
+func findSpec(v semver.Version) *spec {
+	for i := range specs {
+		if specs[i].version == v {
+			return &specs[i]
+		}
+	}
+	return nil
+}
+
+func configVersion(cfg interface{}) (semver.Version, error) {
+	t := reflect.TypeOf(cfg)
+	for _, s := range specs {
+		if s.typ == t {
+			return s.version, nil
+		}
+	}
+	return semver.Version{}, fmt.Errorf("unsupported config type %v", t)
+}
+
+// findPath does a breadth-first search of the translation graph so the
+// shortest chain of translators is used.
+func findPath(from, to semver.Version) ([]translator, error) {
+	if findSpec(from) == nil {
+		return nil, fmt.Errorf("unsupported input spec version %s", from)
+	}
+	if findSpec(to) == nil {
+		return nil, fmt.Errorf("unsupported target spec version %s", to)
+	}
+	// version -> edge used to reach it
+	prev := map[semver.Version]*translator{from: nil}
+	queue := []semver.Version{from}
+	for len(queue) > 0 && queue[0] != to {
+		cur := queue[0]
+		queue = queue[1:]
+		for i := range translators {
+			e := &translators[i]
+			if e.from != cur {
+				continue
+			}
+			// only cross between spec 2 and spec 3 towards the target;
+			// going through the other major version and back is lossy
+			// and may need information (e.g. the fsMap) we don't have
+			if e.from.Major != e.to.Major && e.to.Major != to.Major {
+				continue
+			}
+			if _, seen := prev[e.to]; seen {
+				continue
+			}
+			prev[e.to] = e
+			queue = append(queue, e.to)
+		}
+	}
+	if _, ok := prev[to]; !ok {
+		return nil, fmt.Errorf("no translation path from spec %s to spec %s", from, to)
+	}
+	var ret []translator
+	for v := to; prev[v] != nil; v = prev[v].from {
+		ret = append([]translator{*prev[v]}, ret...)
+	}
+	return ret, nil
+}
+
+// Versions returns every spec version a config can be translated to, in
+// ascending order.
+func Versions() []semver.Version {
+	ret := make([]semver.Version, 0, len(specs))
+	for _, s := range specs {
+		ret = append(ret, s.version)
+	}
+	return ret
+}
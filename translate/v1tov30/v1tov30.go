@@ -0,0 +1,124 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1tov30
+
+import (
+	"fmt"
+	"reflect"
+
+	old "github.com/coreos/ignition/config/v1/types"
+	"github.com/coreos/ignition/config/v2_0"
+	"github.com/coreos/ignition/config/v2_1"
+	"github.com/coreos/ignition/config/v2_2"
+	"github.com/coreos/ignition/config/v2_3"
+	types2_3 "github.com/coreos/ignition/config/v2_3/types"
+	oldValidate "github.com/coreos/ignition/config/validate"
+	"github.com/coreos/ignition/v2/config/v3_0/types"
+
+	"github.com/coreos/ign-converter/translate/v23tov30"
+	"github.com/coreos/ign-converter/util"
+)
+
+// Check1 returns if the config is translatable but does not do any translation.
+// Spec v1 filesystems have no name, so fsMap is a map from the v1 filesystem
+// device to the path under which it should be mounted in v3. A filesystem
+// mapped to "/" is treated as the root filesystem.
+func Check1(cfg old.Config, fsMap map[string]string) error {
+	rpt := oldValidate.ValidateWithoutSource(reflect.ValueOf(cfg))
+	if rpt.IsFatal() {
+		return fmt.Errorf("Invalid input config:\n%s", rpt.String())
+	}
+
+	var errs util.Errors
+
+	if len(cfg.Networkd.Units) != 0 {
+		errs.Add(util.UsesNetworkdError)
+	}
+
+	for i, fs := range cfg.Storage.Filesystems {
+		p, ok := fsMap[string(fs.Device)]
+		if !ok {
+			errs.Add(util.NoFilesystemError{
+				Path: fmt.Sprintf("$.storage.filesystems[%d].device", i),
+				Name: string(fs.Device),
+			})
+		} else if p == "/" && fs.Create != nil {
+			errs.Add(util.CreateRootError{
+				Path:   fmt.Sprintf("$.storage.filesystems[%d].create", i),
+				Device: string(fs.Device),
+			})
+		}
+	}
+
+	return errs.ErrorOrNil()
+}
+
+// Translate translates spec v1 to v3.0. The config is first taken through
+// Ignition's own v1 -> v2.3 translators, then the constructs deprecated in
+// v2.3 are migrated with v23tov30.MigrateDeprecated before handing off to
+// v23tov30. Partition dimensions in sectors are converted to MiB assuming
+// util.DefaultSectorSize.
+func Translate(cfg old.Config, fsMap map[string]string) (types.Config, error) {
+	return TranslateWithSectorSize(cfg, fsMap, 0)
+}
+
+// TranslateWithSectorSize is like Translate, converting partition dimensions
+// with a logical sector size of sectorSize bytes, 512 or 4096.
+func TranslateWithSectorSize(cfg old.Config, fsMap map[string]string, sectorSize int) (types.Config, error) {
+	if err := Check1(cfg, fsMap); err != nil {
+		return types.Config{}, err
+	}
+
+	next := v2_3.Translate(v2_2.TranslateFromV2_1(v2_1.TranslateFromV2_0(v2_0.TranslateFromV1(cfg))))
+
+	// The v1 -> v2.0 translator names filesystems by index, in order
+	nextMap := map[string]string{}
+	names := map[string]string{}
+	for i, fs := range cfg.Storage.Filesystems {
+		name := next.Storage.Filesystems[i].Name
+		if fsMap[string(fs.Device)] == "/" {
+			names[name] = "root"
+		} else {
+			nextMap[name] = fsMap[string(fs.Device)]
+		}
+	}
+	translateFilesystems(&next, names)
+	next, _, err := v23tov30.MigrateDeprecated(next, sectorSize)
+	if err != nil {
+		return types.Config{}, err
+	}
+
+	return v23tov30.Translate(next, nextMap)
+}
+
+// translateFilesystems drops the filesystems mapped to "/" and moves the
+// files on them to the root filesystem.
+func translateFilesystems(cfg *types2_3.Config, names map[string]string) {
+	var fss []types2_3.Filesystem
+	for _, fs := range cfg.Storage.Filesystems {
+		if names[fs.Name] == "root" {
+			// root is implied
+			continue
+		}
+		fss = append(fss, fs)
+	}
+	cfg.Storage.Filesystems = fss
+
+	for i, f := range cfg.Storage.Files {
+		if name, ok := names[f.Filesystem]; ok {
+			cfg.Storage.Files[i].Filesystem = name
+		}
+	}
+}
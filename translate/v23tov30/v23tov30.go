@@ -19,11 +19,13 @@ import (
 	"fmt"
 	"path"
 	"reflect"
+	"strings"
 
 	old "github.com/coreos/ignition/config/v2_3/types"
 	oldValidate "github.com/coreos/ignition/config/validate"
 	"github.com/coreos/ignition/v2/config/v3_0/types"
 	"github.com/coreos/ignition/v2/config/validate"
+	"github.com/vincent-petithory/dataurl"
 
 	"github.com/coreos/ign-converter/util"
 )
@@ -32,14 +34,24 @@ import (
 // fsMap is a map from v2 filesystem names to the paths under which they should
 // be mounted in v3.
 func Check2_3(cfg old.Config, fsMap map[string]string) error {
+	return check(cfg, fsMap, false)
+}
+
+// check is Check2_3, also accepting files appending to a file declared
+// earlier in the config if foldAppends is set
+func check(cfg old.Config, fsMap map[string]string, foldAppends bool) error {
 	rpt := oldValidate.ValidateWithoutSource(reflect.ValueOf(cfg))
 	if rpt.IsFatal() || rpt.IsDeprecated() {
 		// disallow any deprecated fields
 		return fmt.Errorf("Invalid input config:\n%s", rpt.String())
 	}
 
+	// the remaining checks are independent, so report all of their
+	// problems at once
+	var errs util.Errors
+
 	if len(cfg.Networkd.Units) != 0 {
-		return util.UsesNetworkdError
+		errs.Add(util.UsesNetworkdError)
 	}
 
 	// check that all filesystems have a path
@@ -47,9 +59,20 @@ func Check2_3(cfg old.Config, fsMap map[string]string) error {
 		fsMap = map[string]string{}
 	}
 	fsMap["root"] = "/"
-	for _, fs := range cfg.Storage.Filesystems {
+	for i, fs := range cfg.Storage.Filesystems {
 		if _, ok := fsMap[fs.Name]; !ok {
-			return util.NoFilesystemError(fs.Name)
+			errs.Add(util.NoFilesystemError{
+				Path: fmt.Sprintf("$.storage.filesystems[%d].name", i),
+				Name: fs.Name,
+			})
+		}
+	}
+
+	// check that files, links and directories are not on a filesystem
+	// without a mountpoint, which path.Join would put on the root
+	checkMountpoint := func(n old.Node, field string) {
+		if p, ok := fsMap[n.Filesystem]; ok && p == "" {
+			errs.Add(util.NoMountpointError{Path: field + ".filesystem", Name: n.Filesystem})
 		}
 	}
 
@@ -64,74 +87,495 @@ func Check2_3(cfg old.Config, fsMap map[string]string) error {
 		links = append(links, pathString)
 	}
 
-	for _, file := range cfg.Storage.Files {
+	for i, file := range cfg.Storage.Files {
 		pathString := path.Join("/", fsMap[file.Filesystem], file.Path)
 		name := fmt.Sprintf("File: %s", pathString)
-		if duplicate, isDup := entryMap[pathString]; isDup {
-			return util.DuplicateInodeError{Old: duplicate, New: name}
+		field := fmt.Sprintf("$.storage.files[%d].path", i)
+		checkMountpoint(file.Node, fmt.Sprintf("$.storage.files[%d]", i))
+		if duplicate, isDup := entryMap[pathString]; !isDup {
+			entryMap[pathString] = name
+		} else if !foldAppends || !file.Append {
+			errs.Add(util.DuplicateInodeError{Path: field, Old: duplicate, New: name})
 		}
 		if l := util.CheckPathUsesLink(links, pathString); l != "" {
-			return &util.UsesOwnLinkError{
+			errs.Add(util.UsesOwnLinkError{
+				Path:     field,
 				LinkPath: l,
 				Name:     name,
-			}
+			})
 		}
-		entryMap[pathString] = name
 	}
-	for _, dir := range cfg.Storage.Directories {
+	for i, dir := range cfg.Storage.Directories {
 		pathString := path.Join("/", fsMap[dir.Filesystem], dir.Path)
 		name := fmt.Sprintf("Directory: %s", pathString)
+		field := fmt.Sprintf("$.storage.directories[%d].path", i)
+		checkMountpoint(dir.Node, fmt.Sprintf("$.storage.directories[%d]", i))
 		if duplicate, isDup := entryMap[pathString]; isDup {
-			return util.DuplicateInodeError{Old: duplicate, New: name}
+			errs.Add(util.DuplicateInodeError{Path: field, Old: duplicate, New: name})
+		} else {
+			entryMap[pathString] = name
 		}
 		if l := util.CheckPathUsesLink(links, pathString); l != "" {
-			return &util.UsesOwnLinkError{
+			errs.Add(util.UsesOwnLinkError{
+				Path:     field,
 				LinkPath: l,
 				Name:     name,
-			}
+			})
 		}
-		entryMap[pathString] = name
 	}
-	for _, link := range cfg.Storage.Links {
+	for i, link := range cfg.Storage.Links {
 		pathString := path.Join("/", fsMap[link.Filesystem], link.Path)
 		name := fmt.Sprintf("Link: %s", pathString)
+		field := fmt.Sprintf("$.storage.links[%d].path", i)
+		checkMountpoint(link.Node, fmt.Sprintf("$.storage.links[%d]", i))
 		if duplicate, isDup := entryMap[pathString]; isDup {
-			return &util.DuplicateInodeError{Old: duplicate, New: name}
+			errs.Add(util.DuplicateInodeError{Path: field, Old: duplicate, New: name})
+		} else {
+			entryMap[pathString] = name
 		}
-		entryMap[pathString] = name
 		if l := util.CheckPathUsesLink(links, pathString); l != "" {
-			return &util.UsesOwnLinkError{
+			errs.Add(util.UsesOwnLinkError{
+				Path:     field,
 				LinkPath: l,
 				Name:     name,
-			}
+			})
 		}
 	}
 
 	// check that there are no duplicates with systemd units or dropins
 	unitMap := map[string]struct{}{} // unit name -> struct{}
-	for _, unit := range cfg.Systemd.Units {
+	for i, unit := range cfg.Systemd.Units {
 		if _, isDup := unitMap[unit.Name]; isDup {
-			return util.DuplicateUnitError{Name: unit.Name}
+			errs.Add(util.DuplicateUnitError{
+				Path: fmt.Sprintf("$.systemd.units[%d].name", i),
+				Name: unit.Name,
+			})
 		}
 		unitMap[unit.Name] = struct{}{}
 
 		dropinMap := map[string]struct{}{} // dropin name -> struct{}
-		for _, dropin := range unit.Dropins {
+		for j, dropin := range unit.Dropins {
 			if _, isDup := dropinMap[dropin.Name]; isDup {
-				return util.DuplicateDropinError{Unit: unit.Name, Name: dropin.Name}
+				errs.Add(util.DuplicateDropinError{
+					Path: fmt.Sprintf("$.systemd.units[%d].dropins[%d].name", i, j),
+					Unit: unit.Name,
+					Name: dropin.Name,
+				})
 			}
 			dropinMap[dropin.Name] = struct{}{}
 		}
 	}
 
-	return nil
+	return errs.ErrorOrNil()
+}
+
+// nodePath returns the absolute path of n, using fsMap to look up the path
+// of its filesystem.
+func nodePath(n old.Node, fsMap map[string]string) string {
+	if n.Filesystem == "root" {
+		return path.Join("/", n.Path)
+	}
+	return path.Join("/", fsMap[n.Filesystem], n.Path)
+}
+
+// ResolveLinks returns cfg with the paths of files, directories and links
+// that go through a symlink defined in the same config, which Check2_3
+// rejects, rewritten to the location the symlink points to (see
+// util.ResolveLinks). Each rewritten path is moved to the root filesystem and
+// reported.
+func ResolveLinks(cfg old.Config, fsMap map[string]string) (old.Config, []util.Rewrite, error) {
+	links := map[string]string{}
+	linkFields := map[string]string{} // path -> JSON path of the link
+	for i, l := range cfg.Storage.Links {
+		if !l.Hard {
+			links[nodePath(l.Node, fsMap)] = l.Target
+			linkFields[nodePath(l.Node, fsMap)] = fmt.Sprintf("$.storage.links[%d]", i)
+		}
+	}
+	if len(links) == 0 {
+		return cfg, nil, nil
+	}
+	// links can themselves be created through other links
+	for changed := true; changed; {
+		changed = false
+		for p, target := range links {
+			resolved, err := resolveLinks(p, links, linkFields[p])
+			if err != nil {
+				return old.Config{}, nil, err
+			}
+			if resolved != p {
+				delete(links, p)
+				links[resolved] = target
+				linkFields[resolved] = linkFields[p]
+				changed = true
+			}
+		}
+	}
+
+	var report []util.Rewrite
+	resolve := func(n *old.Node, field string) error {
+		p := nodePath(*n, fsMap)
+		resolved, err := resolveLinks(p, links, field)
+		if err != nil {
+			return err
+		}
+		if resolved == p {
+			return nil
+		}
+		n.Filesystem = "root"
+		n.Path = resolved
+		report = append(report, util.Rewrite{
+			Path:    field + ".path",
+			Message: fmt.Sprintf("%s resolved through links to %s", p, resolved),
+		})
+		return nil
+	}
+
+	files := append([]old.File{}, cfg.Storage.Files...)
+	for i := range files {
+		if err := resolve(&files[i].Node, fmt.Sprintf("$.storage.files[%d]", i)); err != nil {
+			return old.Config{}, nil, err
+		}
+	}
+	dirs := append([]old.Directory{}, cfg.Storage.Directories...)
+	for i := range dirs {
+		if err := resolve(&dirs[i].Node, fmt.Sprintf("$.storage.directories[%d]", i)); err != nil {
+			return old.Config{}, nil, err
+		}
+	}
+	newLinks := append([]old.Link{}, cfg.Storage.Links...)
+	for i := range newLinks {
+		if err := resolve(&newLinks[i].Node, fmt.Sprintf("$.storage.links[%d]", i)); err != nil {
+			return old.Config{}, nil, err
+		}
+	}
+	cfg.Storage.Files = files
+	cfg.Storage.Directories = dirs
+	cfg.Storage.Links = newLinks
+	return cfg, report, nil
+}
+
+// resolveLinks is util.ResolveLinks for the entry at the JSON path field
+func resolveLinks(p string, links map[string]string, field string) (string, error) {
+	resolved, err := util.ResolveLinks(p, links)
+	if cycle, ok := err.(util.LinkCycleError); ok {
+		cycle.Path = field + ".path"
+		return "", cycle
+	}
+	return resolved, err
+}
+
+// MigrateDeprecated returns cfg with the deprecated fields rewritten to their
+// non-deprecated v2.3 equivalents, which Check2_3 would otherwise reject,
+// along with a list of the rewrites performed:
+//   - passwd.users[].create is moved to the top-level user fields that are
+//     unset, failing with a util.CreateConflictError for every field the
+//     user sets to a different value
+//   - storage.filesystems[].mount.create is moved to wipeFilesystem and options
+//   - partition size and start in sectors are converted to sizeMiB and
+//     startMiB, using a logical sector size of sectorSize bytes (512, 4096,
+//     or 0 for util.DefaultSectorSize)
+//   - systemd.units[].enable is moved to enabled
+func MigrateDeprecated(cfg old.Config, sectorSize int) (old.Config, []util.Rewrite, error) {
+	sectorSize, err := util.CheckSectorSize(sectorSize)
+	if err != nil {
+		return old.Config{}, nil, err
+	}
+	var rewrites []util.Rewrite
+	var errs util.Errors
+
+	users := append([]old.PasswdUser{}, cfg.Passwd.Users...)
+	for i, u := range users {
+		if u.Create == nil {
+			continue
+		}
+		c := u.Create
+		prefix := fmt.Sprintf("$.passwd.users[%d].create", i)
+		if c.UID != nil {
+			if u.UID != nil && *u.UID != *c.UID {
+				errs.Add(util.CreateConflictError{Path: prefix + ".uid"})
+			}
+			u.UID = c.UID
+		}
+		migrateUserField(&errs, &u.Gecos, c.Gecos, prefix+".gecos")
+		migrateUserField(&errs, &u.HomeDir, c.HomeDir, prefix+".homeDir")
+		migrateUserField(&errs, &u.PrimaryGroup, c.PrimaryGroup, prefix+".primaryGroup")
+		migrateUserField(&errs, &u.Shell, c.Shell, prefix+".shell")
+		// false is unset, so the flags cannot conflict
+		u.NoCreateHome = u.NoCreateHome || c.NoCreateHome
+		u.NoUserGroup = u.NoUserGroup || c.NoUserGroup
+		u.System = u.System || c.System
+		u.NoLogInit = u.NoLogInit || c.NoLogInit
+		u.Groups = append([]old.Group{}, u.Groups...)
+		for _, g := range c.Groups {
+			if !hasGroup(u.Groups, old.Group(g)) {
+				u.Groups = append(u.Groups, old.Group(g))
+			}
+		}
+		u.Create = nil
+		users[i] = u
+		rewrites = append(rewrites, util.Rewrite{
+			Path:    fmt.Sprintf("$.passwd.users[%d].create", i),
+			Message: "moved to the top-level user fields",
+		})
+	}
+	if err := errs.ErrorOrNil(); err != nil {
+		return old.Config{}, nil, err
+	}
+	cfg.Passwd.Users = users
+
+	filesystems := append([]old.Filesystem{}, cfg.Storage.Filesystems...)
+	for i, fs := range filesystems {
+		if fs.Mount == nil || fs.Mount.Create == nil {
+			continue
+		}
+		mount := *fs.Mount
+		mount.WipeFilesystem = mount.WipeFilesystem || mount.Create.Force
+		mount.Options = append([]old.MountOption{}, mount.Options...)
+		for _, o := range mount.Create.Options {
+			mount.Options = append(mount.Options, old.MountOption(o))
+		}
+		mount.Create = nil
+		filesystems[i].Mount = &mount
+		rewrites = append(rewrites, util.Rewrite{
+			Path:    fmt.Sprintf("$.storage.filesystems[%d].mount.create", i),
+			Message: "moved to wipeFilesystem and options",
+		})
+	}
+	cfg.Storage.Filesystems = filesystems
+
+	disks := append([]old.Disk{}, cfg.Storage.Disks...)
+	for i, d := range disks {
+		partitions := append([]old.Partition{}, d.Partitions...)
+		for j, p := range partitions {
+			prefix := fmt.Sprintf("$.storage.disks[%d].partitions[%d]", i, j)
+			var err error
+			if p.SizeMiB, err = sectorsToMiB(p.Size, p.SizeMiB, sectorSize); err != nil {
+				return old.Config{}, nil, fmt.Errorf("%s.size: %w", prefix, err)
+			}
+			if p.StartMiB, err = sectorsToMiB(p.Start, p.StartMiB, sectorSize); err != nil {
+				return old.Config{}, nil, fmt.Errorf("%s.start: %w", prefix, err)
+			}
+			if p.Size != nil {
+				rewrites = append(rewrites, util.Rewrite{
+					Path:    prefix + ".size",
+					Message: sectorsMessage(*p.Size, sectorSize, "sizeMiB"),
+				})
+			}
+			if p.Start != nil {
+				rewrites = append(rewrites, util.Rewrite{
+					Path:    prefix + ".start",
+					Message: sectorsMessage(*p.Start, sectorSize, "startMiB"),
+				})
+			}
+			p.Size = nil
+			p.Start = nil
+			partitions[j] = p
+		}
+		disks[i].Partitions = partitions
+	}
+	cfg.Storage.Disks = disks
+
+	units := append([]old.Unit{}, cfg.Systemd.Units...)
+	for i, u := range units {
+		if !u.Enable {
+			continue
+		}
+		// Enabled wins over Enable, as in translateUnits
+		if u.Enabled == nil {
+			u.Enabled = util.BoolPStrict(true)
+		}
+		u.Enable = false
+		units[i] = u
+		rewrites = append(rewrites, util.Rewrite{
+			Path:    fmt.Sprintf("$.systemd.units[%d].enable", i),
+			Message: "moved to enabled",
+		})
+	}
+	cfg.Systemd.Units = units
+
+	return cfg, rewrites, nil
+}
+
+// migrateUserField sets *field to value from a user's create section unless
+// value is unset, adding a util.CreateConflictError for path to errs if the
+// user sets the field to a different value.
+func migrateUserField(errs *util.Errors, field *string, value, path string) {
+	if value == "" {
+		return
+	}
+	if *field != "" && *field != value {
+		errs.Add(util.CreateConflictError{Path: path})
+		return
+	}
+	*field = value
+}
+
+func hasGroup(groups []old.Group, group old.Group) bool {
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+func sectorsMessage(sectors, sectorSize int, field string) string {
+	if sectors == 0 {
+		return "dropped, 0 sectors is the default"
+	}
+	return fmt.Sprintf("%d sectors of %d bytes converted to %s", sectors, sectorSize, field)
+}
+
+// sectorsToMiB converts a partition dimension in sectors to MiB. Zero sectors
+// means the default, as does a nil MiB value. If both are set they must agree.
+func sectorsToMiB(sectors, mib *int, sectorSize int) (*int, error) {
+	if sectors == nil || *sectors == 0 {
+		return mib, nil
+	}
+	bytes := *sectors * sectorSize
+	if bytes%(1024*1024) != 0 {
+		return nil, fmt.Errorf("%d sectors of %d bytes is not a whole number of MiB", *sectors, sectorSize)
+	}
+	converted := bytes / (1024 * 1024)
+	if mib != nil && *mib != converted {
+		return nil, fmt.Errorf("%d sectors of %d bytes does not match %d MiB", *sectors, sectorSize, *mib)
+	}
+	return &converted, nil
+}
+
+// InferFsMap returns fsMap completed with the paths of the filesystems it
+// is missing, inferred from the mount units and filesystem labels in the
+// config (see util.InferFsMap), along with the source of every path.
+func InferFsMap(cfg old.Config, fsMap map[string]string) (map[string]string, []util.InferredPath) {
+	var filesystems []util.FsInfo
+	for _, fs := range cfg.Storage.Filesystems {
+		info := util.FsInfo{Name: fs.Name}
+		if fs.Mount != nil {
+			info.Device = fs.Mount.Device
+			info.Label = util.StrV(fs.Mount.Label)
+			info.UUID = util.StrV(fs.Mount.UUID)
+		}
+		filesystems = append(filesystems, info)
+	}
+	var units []util.UnitInfo
+	for _, u := range cfg.Systemd.Units {
+		info := util.UnitInfo{Name: u.Name, Contents: []string{u.Contents}}
+		for _, d := range u.Dropins {
+			info.Contents = append(info.Contents, d.Contents)
+		}
+		units = append(units, info)
+	}
+	return util.InferFsMap(fsMap, filesystems, units)
+}
+
+// TranslateNetworkd returns cfg with each networkd unit moved to a file in
+// util.NetworkdDir on the root filesystem, and each dropin to a file in the
+// unit's .d directory, since spec 3 has no networkd section. It fails if a
+// file, directory or link in the config already uses one of those paths.
+// fsMap is used to resolve the paths of the existing entries.
+func TranslateNetworkd(cfg old.Config, fsMap map[string]string) (old.Config, error) {
+	if len(cfg.Networkd.Units) == 0 {
+		return cfg, nil
+	}
+	// from path to a pretty-printing description of the entry
+	entryMap := map[string]string{}
+	dirMap := map[string]bool{}
+	for _, f := range cfg.Storage.Files {
+		entryMap[nodePath(f.Node, fsMap)] = fmt.Sprintf("File: %s", nodePath(f.Node, fsMap))
+	}
+	for _, l := range cfg.Storage.Links {
+		entryMap[nodePath(l.Node, fsMap)] = fmt.Sprintf("Link: %s", nodePath(l.Node, fsMap))
+	}
+	for _, d := range cfg.Storage.Directories {
+		dirMap[nodePath(d.Node, fsMap)] = true
+	}
+
+	files := append([]old.File{}, cfg.Storage.Files...)
+	dirs := append([]old.Directory{}, cfg.Storage.Directories...)
+	addFile := func(field, unit, name, contents string) error {
+		p := path.Join(util.NetworkdDir, name)
+		if existing, ok := entryMap[p]; ok {
+			return util.NetworkdConflictError{Path: field, Unit: unit, File: p, Existing: existing}
+		}
+		if dirMap[p] {
+			return util.NetworkdConflictError{Path: field, Unit: unit, File: p, Existing: fmt.Sprintf("Directory: %s", p)}
+		}
+		entryMap[p] = fmt.Sprintf("Networkd unit: %s", unit)
+		files = append(files, old.File{
+			Node: old.Node{
+				Filesystem: "root",
+				Path:       p,
+				Overwrite:  util.BoolPStrict(true),
+			},
+			FileEmbedded1: old.FileEmbedded1{
+				Contents: old.FileContents{
+					Source: "data:," + dataurl.EscapeString(contents),
+				},
+				Mode: util.IntP(0644),
+			},
+		})
+		return nil
+	}
+	for i, u := range cfg.Networkd.Units {
+		field := fmt.Sprintf("$.networkd.units[%d]", i)
+		if u.Contents != "" {
+			if err := addFile(field, u.Name, u.Name, u.Contents); err != nil {
+				return old.Config{}, err
+			}
+		}
+		if len(u.Dropins) == 0 {
+			continue
+		}
+		dir := path.Join(util.NetworkdDir, u.Name+".d")
+		if existing, ok := entryMap[dir]; ok {
+			return old.Config{}, util.NetworkdConflictError{Path: field + ".dropins", Unit: u.Name, File: dir, Existing: existing}
+		}
+		if !dirMap[dir] {
+			dirMap[dir] = true
+			dirs = append(dirs, old.Directory{
+				Node: old.Node{
+					Filesystem: "root",
+					Path:       dir,
+				},
+				DirectoryEmbedded1: old.DirectoryEmbedded1{
+					Mode: util.IntP(0755),
+				},
+			})
+		}
+		for j, d := range u.Dropins {
+			if err := addFile(fmt.Sprintf("%s.dropins[%d]", field, j), u.Name, path.Join(u.Name+".d", d.Name), d.Contents); err != nil {
+				return old.Config{}, err
+			}
+		}
+	}
+
+	cfg.Storage.Files = files
+	cfg.Storage.Directories = dirs
+	cfg.Networkd = old.Networkd{}
+	return cfg, nil
 }
 
 // Translate translates spec v2.3 to v3.0
 func Translate(cfg old.Config, fsMap map[string]string) (types.Config, error) {
-	if err := Check2_3(cfg, fsMap); err != nil {
-		return types.Config{}, err
+	ret, _, err := translate(cfg, fsMap, false)
+	return ret, err
+}
+
+// TranslateFoldingAppends is like Translate, but folds files appending to a
+// file declared earlier in the config into its Append list, as left by
+// RemoveDuplicates, instead of rejecting them. The spec 3 file keeps the
+// mode, owner and overwrite of the earlier file, so a Rewrite is returned
+// for every folded file setting different ones.
+func TranslateFoldingAppends(cfg old.Config, fsMap map[string]string) (types.Config, []util.Rewrite, error) {
+	return translate(cfg, fsMap, true)
+}
+
+func translate(cfg old.Config, fsMap map[string]string, foldAppends bool) (types.Config, []util.Rewrite, error) {
+	if err := check(cfg, fsMap, foldAppends); err != nil {
+		return types.Config{}, nil, err
 	}
+	files, report := translateFiles(cfg.Storage.Files, fsMap)
 	res := types.Config{
 		// Ignition section
 		Ignition: types.Ignition{
@@ -162,16 +606,16 @@ func Translate(cfg old.Config, fsMap map[string]string) (types.Config, error) {
 			Disks:       translateDisks(cfg.Storage.Disks),
 			Raid:        translateRaid(cfg.Storage.Raid),
 			Filesystems: translateFilesystems(cfg.Storage.Filesystems, fsMap),
-			Files:       translateFiles(cfg.Storage.Files, fsMap),
+			Files:       files,
 			Directories: translateDirectories(cfg.Storage.Directories, fsMap),
 			Links:       translateLinks(cfg.Storage.Links, fsMap),
 		},
 	}
 	r := validate.ValidateWithContext(res, nil)
 	if r.IsFatal() {
-		return types.Config{}, errors.New(r.String())
+		return types.Config{}, nil, errors.New(r.String())
 	}
-	return res, nil
+	return res, report, nil
 }
 
 func translateCfgRef(ref *old.ConfigReference) (ret types.ConfigReference) {
@@ -388,8 +832,14 @@ func translateNode(n old.Node, m map[string]string) types.Node {
 	}
 }
 
-func translateFiles(files []old.File, m map[string]string) (ret []types.File) {
-	for _, f := range files {
+// translateFiles translates files, folding appends to a file declared
+// earlier into its Append list. It returns a Rewrite for every folded file
+// setting attributes different from those of the file it was folded into.
+func translateFiles(files []old.File, m map[string]string) (ret []types.File, report []util.Rewrite) {
+	// from path to the index of the file in ret and in files
+	fileIndex := map[string]int{}
+	sourceIndex := map[string]int{}
+	for i, f := range files {
 		// 2.x files are overwrite by default
 		if f.Node.Overwrite == nil {
 			f.Node.Overwrite = util.BoolP(true)
@@ -413,16 +863,49 @@ func translateFiles(files []old.File, m map[string]string) (ret []types.File) {
 		}
 		c.Verification.Hash = f.FileEmbedded1.Contents.Verification.Hash
 
+		if j, ok := fileIndex[file.Path]; ok && f.Append {
+			// fold the append into the earlier file, see TranslateFoldingAppends
+			ret[j].Append = append(ret[j].Append, c)
+			if diff := fileAttributeDiff(files[sourceIndex[file.Path]], files[i]); len(diff) != 0 {
+				report = append(report, util.Rewrite{
+					Path:    fmt.Sprintf("$.storage.files[%d]", i),
+					Message: fmt.Sprintf("appended to $.storage.files[%d], ignoring its %s", sourceIndex[file.Path], strings.Join(diff, ", ")),
+				})
+			}
+			continue
+		}
 		if f.Append {
 			file.Append = []types.FileContents{c}
 		} else {
 			file.Contents = c
 		}
+		fileIndex[file.Path] = len(ret)
+		sourceIndex[file.Path] = i
 		ret = append(ret, file)
 	}
 	return
 }
 
+// fileAttributeDiff returns the attributes set by the append entry a that
+// differ from those of base, the file it is folded into. Appends never
+// overwrite, so only an overwrite set explicitly can differ.
+func fileAttributeDiff(base, a old.File) (diff []string) {
+	if !reflect.DeepEqual(base.Mode, a.Mode) {
+		diff = append(diff, "mode")
+	}
+	if !reflect.DeepEqual(base.User, a.User) {
+		diff = append(diff, "user")
+	}
+	if !reflect.DeepEqual(base.Group, a.Group) {
+		diff = append(diff, "group")
+	}
+	// 2.x files are overwrite by default
+	if a.Overwrite != nil && *a.Overwrite != (base.Overwrite == nil || *base.Overwrite) {
+		diff = append(diff, "overwrite")
+	}
+	return
+}
+
 func translateLinks(links []old.Link, m map[string]string) (ret []types.Link) {
 	for _, l := range links {
 		ret = append(ret, types.Link{
@@ -455,96 +938,248 @@ func translateDirectories(dirs []old.Directory, m map[string]string) (ret []type
 // may be useful in cases where configuration has to be sanitized before translation.
 // For duplicates, it takes ordering into consideration by taking the file/unit contents from
 // the slice with the highest index value, which is assumed to be the latest revision.
+// Files are considered duplicates if they resolve to the same absolute path, using fsMap to
+// look up the paths of non-root filesystems. Appends to a file are kept in order after the
+// latest non-append entry for its path, which TranslateFoldingAppends translates to a single
+// spec 3 file with an Append list; entries before that non-append entry are dropped, since it
+// overwrites them.
 // Unit dropins are concat'ed, i.e. if no duplicate dropin of the same name exists it is added
-// to the list of dropins of the deduplicated unit definition.
-// The function will fail if a non-root filesystem is declared on any file.
-// It will also fail if file appendices are encountered.
-func RemoveDuplicateFilesUnitsUsers(cfg old.Config) (old.Config, error) {
-	files := cfg.Storage.Files
-	units := cfg.Systemd.Units
-	users := cfg.Passwd.Users
-
-	filePathMap := map[string]bool{}
-	var outFiles []old.File
+// to the list of dropins of the deduplicated unit definition. A unit that only adds dropins
+// keeps the contents of the latest duplicate that has them.
+// See RemoveDuplicates for also deduplicating directories, links and groups.
+func RemoveDuplicateFilesUnitsUsers(cfg old.Config, fsMap map[string]string) (old.Config, error) {
+	files, _, err := removeDuplicateFiles(cfg.Storage.Files, fsMap)
+	if err != nil {
+		return old.Config{}, err
+	}
+	// files, units, and users should now have all duplication removed
+	cfg.Storage.Files = files
+	cfg.Systemd.Units, _ = removeDuplicateUnits(cfg.Systemd.Units)
+	cfg.Passwd.Users, _ = removeDuplicateUsers(cfg.Passwd.Users)
+	return cfg, nil
+}
+
+// RemoveDuplicates is like RemoveDuplicateFilesUnitsUsers, but also removes duplicated
+// directories and links (by resolved path) and groups (by name), keeping the entry with the
+// highest index. It returns every entry that was discarded or merged into another one.
+func RemoveDuplicates(cfg old.Config, fsMap map[string]string) (old.Config, []util.Rewrite, error) {
+	var report, r []util.Rewrite
+	var err error
+	if cfg.Storage.Files, r, err = removeDuplicateFiles(cfg.Storage.Files, fsMap); err != nil {
+		return old.Config{}, nil, err
+	}
+	report = append(report, r...)
+	if cfg.Storage.Directories, r, err = removeDuplicateDirectories(cfg.Storage.Directories, fsMap); err != nil {
+		return old.Config{}, nil, err
+	}
+	report = append(report, r...)
+	if cfg.Storage.Links, r, err = removeDuplicateLinks(cfg.Storage.Links, fsMap); err != nil {
+		return old.Config{}, nil, err
+	}
+	report = append(report, r...)
+	cfg.Systemd.Units, r = removeDuplicateUnits(cfg.Systemd.Units)
+	report = append(report, r...)
+	cfg.Passwd.Users, r = removeDuplicateUsers(cfg.Passwd.Users)
+	report = append(report, r...)
+	cfg.Passwd.Groups, r = removeDuplicateGroups(cfg.Passwd.Groups)
+	report = append(report, r...)
+	return cfg, report, nil
+}
+
+// checkFilesystem returns an error if n, the entry at the JSON path field,
+// is on a filesystem missing from fsMap
+func checkFilesystem(n old.Node, field string, fsMap map[string]string) error {
+	if _, ok := fsMap[n.Filesystem]; !ok && n.Filesystem != "root" {
+		return util.NoFilesystemError{Path: field + ".filesystem", Name: n.Filesystem}
+	}
+	return nil
+}
+
+func removeDuplicateFiles(files []old.File, fsMap map[string]string) ([]old.File, []util.Rewrite, error) {
+	type fileGroup struct {
+		base    int   // index of the latest non-append entry, or -1
+		appends []int // from highest to lowest index
+	}
+	filePathMap := map[string]*fileGroup{}
+	var groups []*fileGroup
+	var report []util.Rewrite
 	// range from highest to lowest index
 	for i := len(files) - 1; i >= 0; i-- {
-		if files[i].Filesystem != "root" {
-			return old.Config{}, errors.New("cannot dedupe set of files on non-root filesystem")
+		if err := checkFilesystem(files[i].Node, fmt.Sprintf("$.storage.files[%d]", i), fsMap); err != nil {
+			return nil, nil, err
 		}
-		if files[i].Append {
-			return old.Config{}, errors.New("cannot dedupe set of files that contains appendices")
+		path := nodePath(files[i].Node, fsMap)
+		group, ok := filePathMap[path]
+		if !ok {
+			group = &fileGroup{base: -1}
+			filePathMap[path] = group
+			groups = append(groups, group)
 		}
-		path := files[i].Path
-		if _, isDup := filePathMap[path]; isDup {
+		if group.base != -1 {
 			// dupes are ignored
+			report = append(report, util.Rewrite{
+				Path:    fmt.Sprintf("$.storage.files[%d]", i),
+				Message: fmt.Sprintf("discarded, overwritten by $.storage.files[%d]", group.base),
+			})
+			continue
+		}
+		if files[i].Append {
+			group.appends = append(group.appends, i)
+		} else {
+			group.base = i
+		}
+	}
+	var outFiles []old.File
+	for _, group := range groups {
+		if group.base != -1 {
+			outFiles = append(outFiles, files[group.base])
+		}
+		for i := len(group.appends) - 1; i >= 0; i-- {
+			outFiles = append(outFiles, files[group.appends[i]])
+		}
+	}
+	return outFiles, report, nil
+}
+
+func removeDuplicateDirectories(dirs []old.Directory, fsMap map[string]string) ([]old.Directory, []util.Rewrite, error) {
+	latest := map[string]int{} // path -> highest index
+	for i, d := range dirs {
+		if err := checkFilesystem(d.Node, fmt.Sprintf("$.storage.directories[%d]", i), fsMap); err != nil {
+			return nil, nil, err
+		}
+		latest[nodePath(d.Node, fsMap)] = i
+	}
+	var outDirs []old.Directory
+	var report []util.Rewrite
+	for i, d := range dirs {
+		if j := latest[nodePath(d.Node, fsMap)]; j != i {
+			report = append(report, util.Rewrite{
+				Path:    fmt.Sprintf("$.storage.directories[%d]", i),
+				Message: fmt.Sprintf("discarded, duplicate of $.storage.directories[%d]", j),
+			})
 			continue
 		}
-		// append unique file
-		outFiles = append(outFiles, files[i])
-		filePathMap[path] = true
+		outDirs = append(outDirs, d)
 	}
+	return outDirs, report, nil
+}
 
-	unitNameMap := map[string]bool{}
+func removeDuplicateLinks(links []old.Link, fsMap map[string]string) ([]old.Link, []util.Rewrite, error) {
+	latest := map[string]int{} // path -> highest index
+	for i, l := range links {
+		if err := checkFilesystem(l.Node, fmt.Sprintf("$.storage.links[%d]", i), fsMap); err != nil {
+			return nil, nil, err
+		}
+		latest[nodePath(l.Node, fsMap)] = i
+	}
+	var outLinks []old.Link
+	var report []util.Rewrite
+	for i, l := range links {
+		if j := latest[nodePath(l.Node, fsMap)]; j != i {
+			report = append(report, util.Rewrite{
+				Path:    fmt.Sprintf("$.storage.links[%d]", i),
+				Message: fmt.Sprintf("discarded, duplicate of $.storage.links[%d]", j),
+			})
+			continue
+		}
+		outLinks = append(outLinks, l)
+	}
+	return outLinks, report, nil
+}
+
+func removeDuplicateGroups(groups []old.PasswdGroup) ([]old.PasswdGroup, []util.Rewrite) {
+	latest := map[string]int{} // name -> highest index
+	for i, g := range groups {
+		latest[g.Name] = i
+	}
+	var outGroups []old.PasswdGroup
+	var report []util.Rewrite
+	for i, g := range groups {
+		if j := latest[g.Name]; j != i {
+			report = append(report, util.Rewrite{
+				Path:    fmt.Sprintf("$.passwd.groups[%d]", i),
+				Message: fmt.Sprintf("discarded, duplicate of $.passwd.groups[%d]", j),
+			})
+			continue
+		}
+		outGroups = append(outGroups, g)
+	}
+	return outGroups, report
+}
+
+func removeDuplicateUnits(units []old.Unit) ([]old.Unit, []util.Rewrite) {
+	unitNameMap := map[string]int{} // unit name -> index of the kept unit
 	var outUnits []old.Unit
+	var outIndex []int // index in units of each entry of outUnits
+	var report []util.Rewrite
 	// range from highest to lowest index
 	for i := len(units) - 1; i >= 0; i-- {
 		unitName := units[i].Name
-		if _, isDup := unitNameMap[unitName]; isDup {
-			// this is a duplicated unit by name
-			if len(units[i].Dropins) > 0 {
-				for j := range outUnits {
-					if outUnits[j].Name == unitName {
-						// outUnits[j] is the highest priority entry with this unit name
-						// now loop over the new unit's dropins and append it if the name
-						// isn't duplicated in the existing unit's dropins
-						for _, newDropin := range units[i].Dropins {
-							hasExistingDropin := false
-							for _, existingDropin := range outUnits[j].Dropins {
-								if existingDropin.Name == newDropin.Name {
-									hasExistingDropin = true
-									break
-								}
-							}
-							if !hasExistingDropin {
-								outUnits[j].Dropins = append(outUnits[j].Dropins, newDropin)
-							}
-						}
-					}
-				}
-			}
-		} else {
+		k, isDup := unitNameMap[unitName]
+		if !isDup {
 			// append unique unit
+			unitNameMap[unitName] = len(outUnits)
 			outUnits = append(outUnits, units[i])
-			unitNameMap[unitName] = true
+			outIndex = append(outIndex, i)
+			continue
+		}
+		// this is a duplicated unit by name, outUnits[k] is the highest priority
+		// entry with this unit name
+		report = append(report, util.Rewrite{
+			Path:    fmt.Sprintf("$.systemd.units[%d]", i),
+			Message: fmt.Sprintf("merged into $.systemd.units[%d]", outIndex[k]),
+		})
+		if outUnits[k].Contents == "" {
+			// the kept unit only adds dropins
+			outUnits[k].Contents = units[i].Contents
+		}
+		// now loop over the new unit's dropins and append it if the name
+		// isn't duplicated in the existing unit's dropins
+		for d, newDropin := range units[i].Dropins {
+			hasExistingDropin := false
+			for _, existingDropin := range outUnits[k].Dropins {
+				if existingDropin.Name == newDropin.Name {
+					hasExistingDropin = true
+					break
+				}
+			}
+			if hasExistingDropin {
+				report = append(report, util.Rewrite{
+					Path:    fmt.Sprintf("$.systemd.units[%d].dropins[%d]", i, d),
+					Message: fmt.Sprintf("discarded, overridden by a dropin in $.systemd.units[%d]", outIndex[k]),
+				})
+				continue
+			}
+			outUnits[k].Dropins = append(outUnits[k].Dropins, newDropin)
 		}
 	}
+	return outUnits, report
+}
 
-	// Concat sshkey sections into the newest passwdUser in the list
-	// Only the SSHAuthorizedKeys of a duplicate user are considered,
-	// all other fields are ignored.
-	userNameMap := map[string]bool{}
+// Concat sshkey sections into the newest passwdUser in the list
+// Only the SSHAuthorizedKeys of a duplicate user are considered,
+// all other fields are ignored.
+func removeDuplicateUsers(users []old.PasswdUser) ([]old.PasswdUser, []util.Rewrite) {
+	userNameMap := map[string]int{} // user name -> index in outUsers
 	var outUsers []old.PasswdUser
+	var outIndex []int // index in users of each entry of outUsers
+	var report []util.Rewrite
 	// range from highest to lowest index
 	for i := len(users) - 1; i >= 0; i-- {
 		userName := users[i].Name
-		if _, isDup := userNameMap[userName]; isDup {
+		if k, isDup := userNameMap[userName]; isDup {
 			// this is a duplicated user by name, append keys to existing user
-			for j := range outUsers {
-				if outUsers[j].Name == userName {
-					outUsers[j].SSHAuthorizedKeys = append(outUsers[j].SSHAuthorizedKeys, users[i].SSHAuthorizedKeys...)
-				}
-			}
+			outUsers[k].SSHAuthorizedKeys = append(outUsers[k].SSHAuthorizedKeys, users[i].SSHAuthorizedKeys...)
+			report = append(report, util.Rewrite{
+				Path:    fmt.Sprintf("$.passwd.users[%d]", i),
+				Message: fmt.Sprintf("merged into $.passwd.users[%d], only sshAuthorizedKeys are kept", outIndex[k]),
+			})
 		} else {
 			// append unique users
+			userNameMap[userName] = len(outUsers)
 			outUsers = append(outUsers, users[i])
-			userNameMap[userName] = true
+			outIndex = append(outIndex, i)
 		}
 	}
-
-	// outFiles, outUnits, and outUsers should now have all duplication removed
-	cfg.Storage.Files = outFiles
-	cfg.Systemd.Units = outUnits
-	cfg.Passwd.Users = outUsers
-
-	return cfg, nil
+	return outUsers, report
 }
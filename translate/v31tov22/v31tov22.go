@@ -18,79 +18,210 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
-	"strings"
 
 	old "github.com/coreos/ignition/config/v2_2/types"
 	oldValidate "github.com/coreos/ignition/config/validate"
 	"github.com/coreos/ignition/v2/config/v3_1/types"
 	"github.com/coreos/ignition/v2/config/validate"
 
+	"github.com/coreos/ign-converter/translate/v32tov22"
 	"github.com/coreos/ign-converter/util"
 )
 
 // Translate translates Ignition spec config v3.1 to v2.2
 func Translate(cfg types.Config) (old.Config, error) {
+	res, _, err := TranslateWithMapping(cfg)
+	return res, err
+}
+
+// TranslateWithMapping translates Ignition spec config v3.1 to v2.2 like
+// Translate, and also returns the mapping from the generated v2.2 filesystem
+// names to their v3.1 paths. It can be used as the fsMap to translate the
+// result back to spec 3.
+func TranslateWithMapping(cfg types.Config) (old.Config, map[string]string, error) {
+	return TranslateWithSectorSize(cfg, 0)
+}
+
+// TranslateWithSectorSize is like TranslateWithMapping, but converts
+// partition SizeMiB and StartMiB to the sectors used by 2.2 with a logical
+// sector size of sectorSize bytes: 512, 4096, or 0 for util.DefaultSectorSize.
+func TranslateWithSectorSize(cfg types.Config, sectorSize int) (old.Config, map[string]string, error) {
+	res, fsMap, _, err := checkAndTranslate(cfg, sectorSize, nil)
+	return res, fsMap, err
+}
+
+// TranslateLossy is like TranslateWithSectorSize, but drops the fields that
+// cannot be represented in 2.2 instead of failing, and returns every field it
+// dropped.
+func TranslateLossy(cfg types.Config, sectorSize int) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, sectorSize, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like TranslateWithSectorSize, but handles the fields
+// that cannot be represented in 2.2 as policy says, and returns every field
+// it dropped or worked around.
+func TranslateWithPolicy(cfg types.Config, sectorSize int, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, sectorSize, policy)
+}
+
+// coverage maps the fields of the spec 3 config to where they are carried
+// in the 2.2 config, for util.CheckTranslation
+var coverage = util.FieldMap{
+	"$.ignition.config.merge":                   "$.ignition.config.append",
+	"$.storage.disks[*].partitions[*].sizeMiB":  "$.storage.disks[*].partitions[*].size",
+	"$.storage.disks[*].partitions[*].startMiB": "$.storage.disks[*].partitions[*].start",
+	"$.storage.files[*].append[*]":              "$.storage.files[*].contents",
+	"$.storage.filesystems[*]":                  "$.storage.filesystems[*].mount",
+	"$.storage.filesystems[*].path":             "$.storage.filesystems[*].name",
+	// only shouldExist false is unsupported, true is what 2.2 does anyway
+	"$.storage.disks[*].partitions[*].shouldExist": "",
+}
+
+func checkAndTranslate(cfg types.Config, sectorSize int, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
+	sectorSize, err := util.CheckSectorSize(sectorSize)
+	if err != nil {
+		return old.Config{}, nil, nil, err
+	}
+
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return old.Config{}, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+		return old.Config{}, nil, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+	}
+
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(types.Config)
 	}
+	check := util.FeatureCheck{Version: "2.2", Policy: policy}
 
 	// Check for potential issues in the spec 3 config
-	for _, m := range cfg.Ignition.Config.Merge {
+	for i, m := range cfg.Ignition.Config.Merge {
 		if m.Compression != nil {
-			return old.Config{}, fmt.Errorf("Compression in Ignition.Config.Merge is not supported on 2.2")
+			check.Unsupported(fmt.Sprintf("$.ignition.config.merge[%d].compression", i), util.FeatureCompression, func() {
+				cfg.Ignition.Config.Merge[i].Compression = nil
+			})
 		}
 		if m.HTTPHeaders != nil {
-			return old.Config{}, fmt.Errorf("HTTPHeaders in Ignition.Config.Merge are not supported on 2.2")
+			check.Workaround(fmt.Sprintf("$.ignition.config.merge[%d].httpHeaders", i), util.FeatureHTTPHeaders, func() {
+				cfg.Ignition.Config.Merge[i].HTTPHeaders = nil
+			}, func() bool {
+				if !util.IsDataURL(cfg.Ignition.Config.Merge[i].Source) {
+					return false
+				}
+				cfg.Ignition.Config.Merge[i].HTTPHeaders = nil
+				return true
+			})
 		}
 	}
 
 	if cfg.Ignition.Config.Replace.Compression != nil {
-		return old.Config{}, fmt.Errorf("Compression in Ignition.Config.Replace is not supported on 2.2")
+		check.Unsupported("$.ignition.config.replace.compression", util.FeatureCompression, func() {
+			cfg.Ignition.Config.Replace.Compression = nil
+		})
 	}
 
 	if cfg.Ignition.Config.Replace.HTTPHeaders != nil {
-		return old.Config{}, fmt.Errorf("HTTPHeaders in Ignition.Config.Replace are not supported on 2.2")
+		check.Workaround("$.ignition.config.replace.httpHeaders", util.FeatureHTTPHeaders, func() {
+			cfg.Ignition.Config.Replace.HTTPHeaders = nil
+		}, func() bool {
+			if !util.IsDataURL(cfg.Ignition.Config.Replace.Source) {
+				return false
+			}
+			cfg.Ignition.Config.Replace.HTTPHeaders = nil
+			return true
+		})
 	}
 
-	for _, ca := range cfg.Ignition.Security.TLS.CertificateAuthorities {
+	for i, ca := range cfg.Ignition.Security.TLS.CertificateAuthorities {
 		if ca.Compression != nil {
-			return old.Config{}, fmt.Errorf("Compression in Ignition.Security.TLS.CertificateAuthorities is not supported on 2.2")
+			check.Unsupported(fmt.Sprintf("$.ignition.security.tls.certificateAuthorities[%d].compression", i), util.FeatureCompression, func() {
+				cfg.Ignition.Security.TLS.CertificateAuthorities[i].Compression = nil
+			})
 		}
 		if ca.HTTPHeaders != nil {
-			return old.Config{}, fmt.Errorf("HTTPHeaders in Ignition.Security.TLS.CertificateAuthorities are not supported on 2.2")
+			check.Workaround(fmt.Sprintf("$.ignition.security.tls.certificateAuthorities[%d].httpHeaders", i), util.FeatureHTTPHeaders, func() {
+				cfg.Ignition.Security.TLS.CertificateAuthorities[i].HTTPHeaders = nil
+			}, func() bool {
+				if !util.IsDataURL(cfg.Ignition.Security.TLS.CertificateAuthorities[i].Source) {
+					return false
+				}
+				cfg.Ignition.Security.TLS.CertificateAuthorities[i].HTTPHeaders = nil
+				return true
+			})
 		}
 	}
 
 	if cfg.Ignition.Proxy.HTTPProxy != nil || cfg.Ignition.Proxy.HTTPSProxy != nil || cfg.Ignition.Proxy.NoProxy != nil {
-		return old.Config{}, fmt.Errorf("HTTP proxies in Ignition.Proxy are not supported on 2.2")
-	}
-
-	// Size and Start are sectors not MiB in 2.2, so we don't understand them.
-	// Fail for now
-	for _, d := range cfg.Storage.Disks {
-		for _, p := range d.Partitions {
-			if p.SizeMiB != nil || p.StartMiB != nil {
-				return old.Config{}, fmt.Errorf("SizeMiB and StartMiB in Storage.Disks.Partitions is not supported on 2.2")
-			}
-		}
+		check.Unsupported("$.ignition.proxy", util.FeatureProxy, func() {
+			cfg.Ignition.Proxy = types.Proxy{}
+		})
 	}
 
-	for _, fs := range cfg.Storage.Filesystems {
+	for i, fs := range cfg.Storage.Filesystems {
 		if fs.MountOptions != nil {
-			return old.Config{}, fmt.Errorf("MountOptions in Storage.Filesystems is not supported on 2.2")
+			check.Unsupported(fmt.Sprintf("$.storage.filesystems[%d].mountOptions", i), util.FeatureMountOptions, func() {
+				cfg.Storage.Filesystems[i].MountOptions = nil
+			})
 		}
 	}
 
-	for _, f := range cfg.Storage.Files {
+	for i, f := range cfg.Storage.Files {
 		if f.Contents.HTTPHeaders != nil {
-			return old.Config{}, fmt.Errorf("HTTPHeaders in Storage.Files.Contents are not supported on 2.2")
+			check.Workaround(fmt.Sprintf("$.storage.files[%d].contents.httpHeaders", i), util.FeatureHTTPHeaders, func() {
+				cfg.Storage.Files[i].Contents.HTTPHeaders = nil
+			}, func() bool {
+				if !util.IsDataURL(cfg.Storage.Files[i].Contents.Source) {
+					return false
+				}
+				cfg.Storage.Files[i].Contents.HTTPHeaders = nil
+				return true
+			})
 		}
-		for _, a := range f.Append {
+		for j, a := range f.Append {
 			if a.HTTPHeaders != nil {
-				return old.Config{}, fmt.Errorf("HTTPHeaders in Storage.Files.Append.* are not supported on 2.2")
+				check.Workaround(fmt.Sprintf("$.storage.files[%d].append[%d].httpHeaders", i, j), util.FeatureHTTPHeaders, func() {
+					cfg.Storage.Files[i].Append[j].HTTPHeaders = nil
+				}, func() bool {
+					if !util.IsDataURL(cfg.Storage.Files[i].Append[j].Source) {
+						return false
+					}
+					cfg.Storage.Files[i].Append[j].HTTPHeaders = nil
+					return true
+				})
+			}
+		}
+	}
+
+	// 2.2 can neither wipe nor delete partitions
+	for i, d := range cfg.Storage.Disks {
+		var parts []types.Partition
+		changed := false
+		for j, p := range d.Partitions {
+			if util.BoolV(p.WipePartitionEntry) {
+				check.Unsupported(fmt.Sprintf("$.storage.disks[%d].partitions[%d].wipePartitionEntry", i, j), util.FeatureWipePartitionEntry, func() {
+					p.WipePartitionEntry = nil
+					changed = true
+				})
+			}
+			if p.ShouldExist != nil && !*p.ShouldExist {
+				// dropping only the field would create the partition instead
+				dropped := false
+				check.Unsupported(fmt.Sprintf("$.storage.disks[%d].partitions[%d].shouldExist", i, j), util.FeatureShouldExist, func() {
+					dropped = true
+					changed = true
+				})
+				if dropped {
+					continue
+				}
 			}
+			parts = append(parts, p)
 		}
+		if changed {
+			cfg.Storage.Disks[i].Partitions = parts
+		}
+	}
+
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return old.Config{}, nil, nil, err
 	}
 
 	// fsMap is a mapping of filesystems populated via the v3 config, to be
@@ -98,6 +229,8 @@ func Translate(cfg types.Config) (old.Config, error) {
 	// named by the path
 	fsList := generateFsList(cfg.Storage.Filesystems)
 
+	filesystems, fsMap := translateFilesystems(cfg.Storage.Filesystems)
+
 	res := old.Config{
 		// Ignition section
 		Ignition: old.Ignition{
@@ -125,9 +258,9 @@ func Translate(cfg types.Config) (old.Config, error) {
 			Units: translateUnits(cfg.Systemd.Units),
 		},
 		Storage: old.Storage{
-			Disks:       translateDisks(cfg.Storage.Disks),
+			Disks:       translateDisks(cfg.Storage.Disks, sectorSize),
 			Raid:        translateRaid(cfg.Storage.Raid),
-			Filesystems: translateFilesystems(cfg.Storage.Filesystems),
+			Filesystems: filesystems,
 			Files:       translateFiles(cfg.Storage.Files, fsList),
 			Directories: translateDirectories(cfg.Storage.Directories, fsList),
 			Links:       translateLinks(cfg.Storage.Links, fsList),
@@ -137,9 +270,18 @@ func Translate(cfg types.Config) (old.Config, error) {
 	// Sanity check the returned config
 	oldrpt := oldValidate.ValidateWithoutSource(reflect.ValueOf(res))
 	if oldrpt.IsFatal() {
-		return old.Config{}, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return old.Config{}, nil, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+	}
+	if err := util.CheckTranslation(cfg, res, coverage); err != nil {
+		return old.Config{}, nil, nil, err
 	}
-	return res, nil
+	return res, fsMap, check.Degraded, nil
+}
+
+// FilesToNetworkd returns cfg with the files in util.NetworkdDir moved to
+// the networkd section, like v32tov22.FilesToNetworkd.
+func FilesToNetworkd(cfg old.Config) old.Config {
+	return v32tov22.FilesToNetworkd(cfg)
 }
 
 func generateFsList(fss []types.Filesystem) (ret []string) {
@@ -252,22 +394,26 @@ func translateDropins(dropins []types.Dropin) (ret []old.SystemdDropin) {
 	return
 }
 
-func translateDisks(disks []types.Disk) (ret []old.Disk) {
+func translateDisks(disks []types.Disk, sectorSize int) (ret []old.Disk) {
 	for _, d := range disks {
 		ret = append(ret, old.Disk{
 			Device:     d.Device,
 			WipeTable:  util.BoolV(d.WipeTable),
-			Partitions: translatePartitions(d.Partitions),
+			Partitions: translatePartitions(d.Partitions, sectorSize),
 		})
 	}
 	return
 }
 
-func translatePartitions(parts []types.Partition) (ret []old.Partition) {
+// translatePartitions converts SizeMiB and StartMiB to the Size and Start in
+// sectors of 2.2. Zero means the default in both, and so does a nil MiB value.
+func translatePartitions(parts []types.Partition, sectorSize int) (ret []old.Partition) {
 	for _, p := range parts {
 		ret = append(ret, old.Partition{
 			Label:    util.StrV(p.Label),
 			Number:   p.Number,
+			Size:     util.IntV(p.SizeMiB) * (1024 * 1024 / sectorSize),
+			Start:    util.IntV(p.StartMiB) * (1024 * 1024 / sectorSize),
 			TypeGUID: util.StrV(p.TypeGUID),
 			GUID:     util.StrV(p.GUID),
 		})
@@ -302,7 +448,8 @@ func translateRaidOptions(options []types.RaidOption) (ret []old.RaidOption) {
 	return
 }
 
-func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem) {
+func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem, fsMap map[string]string) {
+	fsMap = map[string]string{}
 	// For filesystems that have no explicit path, we will uniquely name them with an int instead
 	inc := 1
 	for _, f := range fss {
@@ -313,6 +460,7 @@ func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem) {
 		} else {
 			fsname = *f.Path
 		}
+		fsMap[fsname] = util.StrV(f.Path)
 
 		ret = append(ret, old.Filesystem{
 			// To construct a mapping for files/directories, we name the filesystem by path uniquely.
@@ -339,17 +487,7 @@ func translateFilesystemOptions(options []types.FilesystemOption) (ret []old.Mou
 }
 
 func translateNode(n types.Node, fss []string) old.Node {
-	fsname := ""
-	path := n.Path
-	for _, fs := range fss {
-		if strings.HasPrefix(n.Path, fs) && len(fs) > len(fsname) {
-			fsname = fs
-			path = strings.TrimPrefix(n.Path, fsname)
-		}
-	}
-	if len(fsname) == 0 {
-		fsname = "root"
-	}
+	fsname, path := util.ResolveFilesystem(n.Path, fss)
 
 	ret := old.Node{
 		Filesystem: fsname,
@@ -396,6 +534,16 @@ func translateFiles(files []types.File, fss []string) (ret []old.File) {
 			file.FileEmbedded1.Append = false
 			ret = append(ret, file)
 		}
+		if f.FileEmbedded1.Contents.Source == nil && len(f.FileEmbedded1.Append) == 0 {
+			// Without contents, spec 3 creates an empty file or keeps the
+			// existing one, only setting its mode and ownership. Appending
+			// nothing to the file does the same in spec 2.
+			file.FileEmbedded1.Contents = old.FileContents{
+				Source: "data:,",
+			}
+			file.FileEmbedded1.Append = true
+			ret = append(ret, file)
+		}
 		if f.FileEmbedded1.Append != nil {
 			for _, fc := range f.FileEmbedded1.Append {
 				appendFile := old.File{
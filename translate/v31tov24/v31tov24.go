@@ -18,45 +18,108 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
-	"strings"
 
 	old "github.com/coreos/ignition/config/v2_4/types"
 	oldValidate "github.com/coreos/ignition/config/validate"
 	"github.com/coreos/ignition/v2/config/v3_1/types"
 	"github.com/coreos/ignition/v2/config/validate"
 
+	"github.com/coreos/ign-converter/translate/v32tov24"
 	"github.com/coreos/ign-converter/util"
 )
 
 // Translate translates Ignition spec config v3.1 to spec v2.4
 func Translate(cfg types.Config) (old.Config, error) {
+	res, _, err := TranslateWithMapping(cfg)
+	return res, err
+}
+
+// TranslateWithMapping translates Ignition spec config v3.1 to v2.4 like
+// Translate, and also returns the mapping from the generated v2.4 filesystem
+// names to their v3.1 paths. It can be used as the fsMap to translate the
+// result back to spec 3.
+func TranslateWithMapping(cfg types.Config) (old.Config, map[string]string, error) {
+	res, fsMap, _, err := checkAndTranslate(cfg, nil)
+	return res, fsMap, err
+}
+
+// TranslateLossy is like TranslateWithMapping, but drops the fields that
+// cannot be represented in 2.4 instead of failing, and returns every field it
+// dropped.
+func TranslateLossy(cfg types.Config) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like TranslateWithMapping, but handles the fields
+// that cannot be represented in 2.4 as policy says, and returns every field
+// it dropped or worked around.
+func TranslateWithPolicy(cfg types.Config, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+// coverage maps the fields of the spec 3 config to where they are carried
+// in the 2.4 config, for util.CheckTranslation
+var coverage = util.FieldMap{
+	"$.ignition.config.merge":       "$.ignition.config.append",
+	"$.storage.files[*].append[*]":  "$.storage.files[*].contents",
+	"$.storage.filesystems[*]":      "$.storage.filesystems[*].mount",
+	"$.storage.filesystems[*].path": "$.storage.filesystems[*].name",
+}
+
+func checkAndTranslate(cfg types.Config, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return old.Config{}, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+		return old.Config{}, nil, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+	}
+
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(types.Config)
 	}
+	check := util.FeatureCheck{Version: "2.4", Policy: policy}
 
 	// Check for potential issues in the spec 3 config
-	for _, m := range cfg.Ignition.Config.Merge {
+	for i, m := range cfg.Ignition.Config.Merge {
 		if m.Compression != nil {
-			return old.Config{}, fmt.Errorf("Compression in Ignition.Config.Merge is not supported on 2.4")
+			check.Unsupported(fmt.Sprintf("$.ignition.config.merge[%d].compression", i), util.FeatureCompression, func() {
+				cfg.Ignition.Config.Merge[i].Compression = nil
+			})
 		}
 	}
 
 	if cfg.Ignition.Config.Replace.Compression != nil {
-		return old.Config{}, fmt.Errorf("Compression in Ignition.Config.Replace is not supported on 2.4")
+		check.Unsupported("$.ignition.config.replace.compression", util.FeatureCompression, func() {
+			cfg.Ignition.Config.Replace.Compression = nil
+		})
 	}
 
-	for _, ca := range cfg.Ignition.Security.TLS.CertificateAuthorities {
+	for i, ca := range cfg.Ignition.Security.TLS.CertificateAuthorities {
 		if ca.Compression != nil {
-			return old.Config{}, fmt.Errorf("Compression in Ignition.Security.TLS.CertificateAuthorities is not supported on 2.4")
+			check.Unsupported(fmt.Sprintf("$.ignition.security.tls.certificateAuthorities[%d].compression", i), util.FeatureCompression, func() {
+				cfg.Ignition.Security.TLS.CertificateAuthorities[i].Compression = nil
+			})
 		}
 	}
 
+	// 2.4 mount options are passed to mkfs, it cannot set mount options
+	for i, fs := range cfg.Storage.Filesystems {
+		if fs.MountOptions != nil {
+			check.Unsupported(fmt.Sprintf("$.storage.filesystems[%d].mountOptions", i), util.FeatureMountOptions, func() {
+				cfg.Storage.Filesystems[i].MountOptions = nil
+			})
+		}
+	}
+
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return old.Config{}, nil, nil, err
+	}
+
 	// fsMap is a mapping of filesystems populated via the v3 config, to be
 	// used for v2 files sections. The naming of each section will be uniquely
 	// named by the path
 	fsList := generateFsList(cfg.Storage.Filesystems)
 
+	filesystems, fsMap := translateFilesystems(cfg.Storage.Filesystems)
+
 	res := old.Config{
 		// Ignition section
 		Ignition: old.Ignition{
@@ -91,7 +154,7 @@ func Translate(cfg types.Config) (old.Config, error) {
 		Storage: old.Storage{
 			Disks:       translateDisks(cfg.Storage.Disks),
 			Raid:        translateRaid(cfg.Storage.Raid),
-			Filesystems: translateFilesystems(cfg.Storage.Filesystems),
+			Filesystems: filesystems,
 			Files:       translateFiles(cfg.Storage.Files, fsList),
 			Directories: translateDirectories(cfg.Storage.Directories, fsList),
 			Links:       translateLinks(cfg.Storage.Links, fsList),
@@ -101,9 +164,18 @@ func Translate(cfg types.Config) (old.Config, error) {
 	// Sanity check the returned config
 	oldrpt := oldValidate.ValidateWithoutSource(reflect.ValueOf(res))
 	if oldrpt.IsFatal() {
-		return old.Config{}, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return old.Config{}, nil, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
 	}
-	return res, nil
+	if err := util.CheckTranslation(cfg, res, coverage); err != nil {
+		return old.Config{}, nil, nil, err
+	}
+	return res, fsMap, check.Degraded, nil
+}
+
+// FilesToNetworkd returns cfg with the files in util.NetworkdDir moved to
+// the networkd section, like v32tov24.FilesToNetworkd.
+func FilesToNetworkd(cfg old.Config) old.Config {
+	return v32tov24.FilesToNetworkd(cfg)
 }
 
 func generateFsList(fss []types.Filesystem) (ret []string) {
@@ -245,7 +317,7 @@ func translatePartitions(parts []types.Partition) (ret []old.Partition) {
 			StartMiB:           p.StartMiB,
 			TypeGUID:           util.StrV(p.TypeGUID),
 			GUID:               util.StrV(p.GUID),
-			WipePartitionEntry: *p.WipePartitionEntry,
+			WipePartitionEntry: util.BoolV(p.WipePartitionEntry),
 			ShouldExist:        p.ShouldExist,
 		})
 	}
@@ -289,7 +361,8 @@ func translateHTTPHeaders(headers []types.HTTPHeader) (ret []old.HTTPHeader) {
 	return
 }
 
-func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem) {
+func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem, fsMap map[string]string) {
+	fsMap = map[string]string{}
 	// For filesystems that have no explicit path, we will uniquely name them with an int instead
 	inc := 1
 	for _, f := range fss {
@@ -300,6 +373,7 @@ func translateFilesystems(fss []types.Filesystem) (ret []old.Filesystem) {
 		} else {
 			fsname = *f.Path
 		}
+		fsMap[fsname] = util.StrV(f.Path)
 
 		ret = append(ret, old.Filesystem{
 			// To construct a mapping for files/directories, we name the filesystem by path uniquely.
@@ -326,17 +400,7 @@ func translateFilesystemOptions(options []types.FilesystemOption) (ret []old.Mou
 }
 
 func translateNode(n types.Node, fss []string) old.Node {
-	fsname := ""
-	path := n.Path
-	for _, fs := range fss {
-		if strings.HasPrefix(n.Path, fs) && len(fs) > len(fsname) {
-			fsname = fs
-			path = strings.TrimPrefix(n.Path, fsname)
-		}
-	}
-	if len(fsname) == 0 {
-		fsname = "root"
-	}
+	fsname, path := util.ResolveFilesystem(n.Path, fss)
 
 	ret := old.Node{
 		Filesystem: fsname,
@@ -384,6 +448,16 @@ func translateFiles(files []types.File, fss []string) (ret []old.File) {
 			file.FileEmbedded1.Append = false
 			ret = append(ret, file)
 		}
+		if f.FileEmbedded1.Contents.Source == nil && len(f.FileEmbedded1.Append) == 0 {
+			// Without contents, spec 3 creates an empty file or keeps the
+			// existing one, only setting its mode and ownership. Appending
+			// nothing to the file does the same in spec 2.
+			file.FileEmbedded1.Contents = old.FileContents{
+				Source: "data:,",
+			}
+			file.FileEmbedded1.Append = true
+			ret = append(ret, file)
+		}
 		if f.FileEmbedded1.Append != nil {
 			for _, fc := range f.FileEmbedded1.Append {
 				appendFile := old.File{
@@ -0,0 +1,223 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v31tov30
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/coreos/ignition/v2/config/translate"
+	"github.com/coreos/ignition/v2/config/v3_0/types"
+	old_types "github.com/coreos/ignition/v2/config/v3_1/types"
+	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/util"
+)
+
+// Copy of github.com/coreos/ignition/v2/config/v3_1/translate/translate.go
+// with the types & old_types imports reversed (the referenced file translates
+// from 3.0 -> 3.1 but as a result only touches fields that are understood by
+// the 3.0 spec).
+func translateFilesystem(old old_types.Filesystem) (ret types.Filesystem) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.Translate(&old.Device, &ret.Device)
+	tr.Translate(&old.Format, &ret.Format)
+	tr.Translate(&old.Label, &ret.Label)
+	tr.Translate(&old.Options, &ret.Options)
+	tr.Translate(&old.Path, &ret.Path)
+	tr.Translate(&old.UUID, &ret.UUID)
+	tr.Translate(&old.WipeFilesystem, &ret.WipeFilesystem)
+	return
+}
+
+func translateConfigReference(old old_types.Resource) (ret types.ConfigReference) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.Translate(&old.Source, &ret.Source)
+	tr.Translate(&old.Verification, &ret.Verification)
+	return
+}
+
+func translateCAReference(old old_types.Resource) (ret types.CaReference) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	if old.Source != nil {
+		ret.Source = *old.Source
+	}
+	tr.Translate(&old.Verification, &ret.Verification)
+	return
+}
+
+func translateFileContents(old old_types.Resource) (ret types.FileContents) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.Translate(&old.Compression, &ret.Compression)
+	tr.Translate(&old.Source, &ret.Source)
+	tr.Translate(&old.Verification, &ret.Verification)
+	return
+}
+
+func translateIgnitionConfig(old old_types.IgnitionConfig) (ret types.IgnitionConfig) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.AddCustomTranslator(translateConfigReference)
+	tr.Translate(&old.Merge, &ret.Merge)
+	tr.Translate(&old.Replace, &ret.Replace)
+	return
+}
+
+func translateSecurity(old old_types.Security) (ret types.Security) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.AddCustomTranslator(translateTLS)
+	tr.Translate(&old.TLS, &ret.TLS)
+	return
+}
+
+func translateTLS(old old_types.TLS) (ret types.TLS) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.AddCustomTranslator(translateCAReference)
+	tr.Translate(&old.CertificateAuthorities, &ret.CertificateAuthorities)
+	return
+}
+
+func translateIgnition(old old_types.Ignition) (ret types.Ignition) {
+	// use a new translator so we don't recurse infinitely
+	tr := translate.NewTranslator()
+	tr.AddCustomTranslator(translateIgnitionConfig)
+	tr.AddCustomTranslator(translateSecurity)
+	tr.Translate(&old.Config, &ret.Config)
+	tr.Translate(&old.Security, &ret.Security)
+	tr.Translate(&old.Timeouts, &ret.Timeouts)
+	ret.Version = types.MaxVersion.String()
+	return
+}
+
+func translateConfig(old old_types.Config) (ret types.Config) {
+	tr := translate.NewTranslator()
+	tr.AddCustomTranslator(translateFileContents)
+	tr.AddCustomTranslator(translateIgnition)
+	tr.AddCustomTranslator(translateFilesystem)
+	tr.Translate(&old, &ret)
+	return
+}
+
+// end copied Ignition v3_1/translate block
+
+// Translate translates Ignition spec config v3.1 to spec v3.0
+func Translate(cfg old_types.Config) (types.Config, error) {
+	res, _, err := checkAndTranslate(cfg, nil)
+	return res, err
+}
+
+// TranslateLossy is like Translate, but drops the fields that cannot be
+// represented in 3.0 instead of failing, and returns every field it dropped.
+func TranslateLossy(cfg old_types.Config) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like Translate, but handles the fields that cannot
+// be represented in 3.0 as policy says, and returns every field it dropped or
+// worked around.
+func TranslateWithPolicy(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+func checkAndTranslate(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	rpt := validate.ValidateWithContext(cfg, nil)
+	if rpt.IsFatal() {
+		return types.Config{}, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+	}
+
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(old_types.Config)
+	}
+	check := util.FeatureCheck{Version: "3.0", Policy: policy}
+
+	util.Walk(reflect.ValueOf(&cfg).Elem(), "$", func(v reflect.Value, path string) bool {
+		return checkValue(&check, v, path)
+	})
+
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return types.Config{}, nil, err
+	}
+
+	res := translateConfig(cfg)
+
+	// Sanity check the returned config
+	oldrpt := validate.ValidateWithContext(res, nil)
+	if oldrpt.IsFatal() {
+		return types.Config{}, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+	}
+	if err := util.CheckTranslation(cfg, res, nil); err != nil {
+		return types.Config{}, nil, err
+	}
+	return res, check.Degraded, nil
+}
+
+// checkValue checks v, which must be addressable, for fields 3.0 cannot
+// represent. It returns false if v itself has to be removed from the config.
+func checkValue(check *util.FeatureCheck, v reflect.Value, path string) bool {
+	switch v.Type() {
+	case reflect.TypeOf(old_types.Resource{}):
+		r := v.Addr().Interface().(*old_types.Resource)
+		// 3.0 only supports compression for file contents
+		if r.Compression != nil && !strings.HasPrefix(path, "$.storage.files[") {
+			check.Unsupported(path+".compression", util.FeatureCompression, func() {
+				r.Compression = nil
+			})
+		}
+		if r.HTTPHeaders != nil {
+			check.Workaround(path+".httpHeaders", util.FeatureHTTPHeaders, func() {
+				r.HTTPHeaders = nil
+			}, func() bool {
+				if !util.IsDataURL(r.Source) {
+					return false
+				}
+				r.HTTPHeaders = nil
+				return true
+			})
+		}
+		if isSha256(r.Verification.Hash) {
+			check.Unsupported(path+".verification.hash", util.FeatureSha256, func() {
+				r.Verification.Hash = nil
+			})
+		}
+	case reflect.TypeOf(old_types.Proxy{}):
+		p := v.Addr().Interface().(*old_types.Proxy)
+		if p.HTTPProxy != nil || p.HTTPSProxy != nil || p.NoProxy != nil {
+			check.Unsupported(path, util.FeatureProxy, func() {
+				*p = old_types.Proxy{}
+			})
+		}
+	case reflect.TypeOf(old_types.Filesystem{}):
+		fs := v.Addr().Interface().(*old_types.Filesystem)
+		if fs.MountOptions != nil {
+			check.Unsupported(path+".mountOptions", util.FeatureMountOptions, func() {
+				fs.MountOptions = nil
+			})
+		}
+	}
+	return true
+}
+
+// isSha256 returns whether hash uses the sha256 function, which was
+// introduced in spec 3.1
+func isSha256(hash *string) bool {
+	return hash != nil && strings.HasPrefix(*hash, "sha256-")
+}
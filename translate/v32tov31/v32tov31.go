@@ -16,11 +16,14 @@ package v32tov31
 
 import (
 	"fmt"
+	"reflect"
 
 	"github.com/coreos/ignition/v2/config/translate"
 	"github.com/coreos/ignition/v2/config/v3_1/types"
 	old_types "github.com/coreos/ignition/v2/config/v3_2/types"
 	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/util"
 )
 
 // Copy of github.com/coreos/ignition/v2/config/v3_2/translate/translate.go
@@ -100,35 +103,48 @@ func translateConfig(old old_types.Config) (ret types.Config) {
 
 // Translate translates Ignition spec config v3.2 to spec v3.1
 func Translate(cfg old_types.Config) (types.Config, error) {
+	res, _, err := checkAndTranslate(cfg, nil)
+	return res, err
+}
+
+// TranslateLossy is like Translate, but drops the fields that cannot be
+// represented in 3.1 instead of failing, and returns every field it dropped.
+func TranslateLossy(cfg old_types.Config) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like Translate, but handles the fields that cannot
+// be represented in 3.1 as policy says, and returns every field it dropped or
+// worked around.
+func TranslateWithPolicy(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+// coverage lists the fields of the 3.2 config consumed by translating it
+// to 3.1, for util.CheckTranslation
+var coverage = util.FieldMap{
+	// only shouldExist false is unsupported, true is what 3.1 does anyway
+	"$.passwd.groups[*].shouldExist": "",
+	"$.passwd.users[*].shouldExist":  "",
+}
+
+func checkAndTranslate(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+		return types.Config{}, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
 	}
 
-	if len(cfg.Storage.Luks) > 0 {
-		return types.Config{}, fmt.Errorf("LUKS is not supported on 3.1")
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(old_types.Config)
 	}
+	check := util.FeatureCheck{Version: "3.1", Policy: policy}
 
-	// ShouldExist for Users & Groups do not exist in 3.1
-	for _, u := range cfg.Passwd.Users {
-		if u.ShouldExist != nil && !*u.ShouldExist {
-			return types.Config{}, fmt.Errorf("ShouldExist in Passwd.Users is not supported on 3.1")
-		}
-	}
-	for _, g := range cfg.Passwd.Groups {
-		if g.ShouldExist != nil && !*g.ShouldExist {
-			return types.Config{}, fmt.Errorf("ShouldExist in Passwd.Groups is not supported on 3.1")
-		}
-	}
+	util.Walk(reflect.ValueOf(&cfg).Elem(), "$", func(v reflect.Value, path string) bool {
+		return checkValue(&check, v, path)
+	})
 
-	// Resize is not in 2.4
-	// Fail for now
-	for _, d := range cfg.Storage.Disks {
-		for _, p := range d.Partitions {
-			if p.Resize != nil && *p.Resize {
-				return types.Config{}, fmt.Errorf("Resize in Storage.Disks.Partitions is not supported on 3.1")
-			}
-		}
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return types.Config{}, nil, err
 	}
 
 	res := translateConfig(cfg)
@@ -136,7 +152,48 @@ func Translate(cfg old_types.Config) (types.Config, error) {
 	// Sanity check the returned config
 	oldrpt := validate.ValidateWithContext(res, nil)
 	if oldrpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return types.Config{}, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+	}
+	if err := util.CheckTranslation(cfg, res, coverage); err != nil {
+		return types.Config{}, nil, err
+	}
+	return res, check.Degraded, nil
+}
+
+// checkValue checks v, which must be addressable, for fields 3.1 cannot
+// represent. It returns false if v itself has to be removed from the config.
+func checkValue(check *util.FeatureCheck, v reflect.Value, path string) bool {
+	keep := true
+	switch v.Type() {
+	case reflect.TypeOf([]old_types.Luks{}):
+		if v.Len() > 0 {
+			check.Unsupported(path, util.FeatureLuks, func() {
+				keep = false
+			})
+		}
+	// ShouldExist for Users & Groups do not exist in 3.1. Dropping only the
+	// field would create the user or group instead, so drop it entirely.
+	case reflect.TypeOf(old_types.PasswdUser{}):
+		u := v.Interface().(old_types.PasswdUser)
+		if u.ShouldExist != nil && !*u.ShouldExist {
+			check.Unsupported(path+".shouldExist", util.FeatureShouldExist, func() {
+				keep = false
+			})
+		}
+	case reflect.TypeOf(old_types.PasswdGroup{}):
+		g := v.Interface().(old_types.PasswdGroup)
+		if g.ShouldExist != nil && !*g.ShouldExist {
+			check.Unsupported(path+".shouldExist", util.FeatureShouldExist, func() {
+				keep = false
+			})
+		}
+	case reflect.TypeOf(old_types.Partition{}):
+		p := v.Addr().Interface().(*old_types.Partition)
+		if p.Resize != nil && *p.Resize {
+			check.Unsupported(path+".resize", util.FeatureResize, func() {
+				p.Resize = nil
+			})
+		}
 	}
-	return res, nil
+	return keep
 }
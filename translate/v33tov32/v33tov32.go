@@ -22,6 +22,8 @@ import (
 	"github.com/coreos/ignition/v2/config/v3_2/types"
 	old_types "github.com/coreos/ignition/v2/config/v3_3/types"
 	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/util"
 )
 
 // Mostly a copy of github.com/coreos/ignition/v2/config/v3_3/translate/translate.go
@@ -113,13 +115,40 @@ func translateConfig(old old_types.Config) (ret types.Config) {
 
 // Translate translates Ignition spec config v3.3 to spec v3.2
 func Translate(cfg old_types.Config) (types.Config, error) {
+	res, _, err := checkAndTranslate(cfg, nil)
+	return res, err
+}
+
+// TranslateLossy is like Translate, but drops the fields that cannot be
+// represented in 3.2 instead of failing, and returns every field it dropped.
+func TranslateLossy(cfg old_types.Config) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like Translate, but handles the fields that cannot
+// be represented in 3.2 as policy says, and returns every field it dropped or
+// worked around.
+func TranslateWithPolicy(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+func checkAndTranslate(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+		return types.Config{}, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
 	}
 
-	if len(cfg.KernelArguments.ShouldExist) > 0 || len(cfg.KernelArguments.ShouldNotExist) > 0 {
-		return types.Config{}, fmt.Errorf("KernelArguments is not supported on 3.2")
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(old_types.Config)
+	}
+	check := util.FeatureCheck{Version: "3.2", Policy: policy}
+
+	util.Walk(reflect.ValueOf(&cfg).Elem(), "$", func(v reflect.Value, path string) bool {
+		return checkValue(&check, v, path)
+	})
+
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return types.Config{}, nil, err
 	}
 
 	res := translateConfig(cfg)
@@ -127,7 +156,25 @@ func Translate(cfg old_types.Config) (types.Config, error) {
 	// Sanity check the returned config
 	oldrpt := validate.ValidateWithContext(res, nil)
 	if oldrpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return types.Config{}, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+	}
+	if err := util.CheckTranslation(cfg, res, nil); err != nil {
+		return types.Config{}, nil, err
+	}
+	return res, check.Degraded, nil
+}
+
+// checkValue checks v, which must be addressable, for fields 3.2 cannot
+// represent. It returns false if v itself has to be removed from the config.
+func checkValue(check *util.FeatureCheck, v reflect.Value, path string) bool {
+	keep := true
+	if v.Type() == reflect.TypeOf(old_types.KernelArguments{}) {
+		args := v.Interface().(old_types.KernelArguments)
+		if len(args.ShouldExist) > 0 || len(args.ShouldNotExist) > 0 {
+			check.Unsupported(path, util.FeatureKernelArguments, func() {
+				keep = false
+			})
+		}
 	}
-	return res, nil
+	return keep
 }
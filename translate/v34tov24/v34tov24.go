@@ -0,0 +1,82 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v34tov24
+
+import (
+	"fmt"
+
+	old "github.com/coreos/ignition/config/v2_4/types"
+	"github.com/coreos/ignition/v2/config/v3_4/types"
+	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/translate/v33tov24"
+	"github.com/coreos/ign-converter/translate/v34tov33"
+	"github.com/coreos/ign-converter/util"
+)
+
+// Translate translates Ignition spec config v3.4 to spec v2.4 by chaining
+// v34tov33 and v33tov24. Fields that cannot be represented in 2.4 are
+// reported against 2.4 rather than against an intermediate spec version.
+func Translate(cfg types.Config) (old.Config, error) {
+	res, _, err := TranslateWithMapping(cfg)
+	return res, err
+}
+
+// TranslateWithMapping translates Ignition spec config v3.4 to v2.4 like
+// Translate, and also returns the mapping from the generated v2.4 filesystem
+// names to their v3.4 paths.
+func TranslateWithMapping(cfg types.Config) (old.Config, map[string]string, error) {
+	res, fsMap, _, err := checkAndTranslate(cfg, nil)
+	return res, fsMap, err
+}
+
+// TranslateLossy is like TranslateWithMapping, but drops the fields that
+// cannot be represented in 2.4 instead of failing, and returns every field it
+// dropped.
+func TranslateLossy(cfg types.Config) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like TranslateWithMapping, but handles the fields
+// that cannot be represented in 2.4 as policy says, and returns every field
+// it dropped or worked around.
+func TranslateWithPolicy(cfg types.Config, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+func checkAndTranslate(cfg types.Config, policy util.Policy) (old.Config, map[string]string, []util.Degradation, error) {
+	rpt := validate.ValidateWithContext(cfg, nil)
+	if rpt.IsFatal() {
+		return old.Config{}, nil, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+	}
+
+	// run every stage even if an earlier one fails, so all problems are
+	// reported at once
+	var errs util.Errors
+	res, degraded, err := v34tov33.TranslateWithPolicy(cfg, policy)
+	if err != nil {
+		errs.Add(err)
+		// carry on with what the next stage can represent
+		if res, _, err = v34tov33.TranslateLossy(cfg); err != nil {
+			return old.Config{}, nil, nil, util.RetargetErrors(errs.ErrorOrNil(), "2.4")
+		}
+	}
+	ret, fsMap, more, err := v33tov24.TranslateWithPolicy(res, policy)
+	errs.Add(err)
+	if err := errs.ErrorOrNil(); err != nil {
+		return old.Config{}, nil, nil, util.RetargetErrors(err, "2.4")
+	}
+	return ret, fsMap, util.Retarget(append(degraded, more...), "2.4"), nil
+}
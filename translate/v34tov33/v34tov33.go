@@ -20,10 +20,12 @@ import (
 	"reflect"
 
 	"github.com/coreos/ignition/v2/config/translate"
-	"github.com/coreos/ignition/v2/config/util"
+	ignutil "github.com/coreos/ignition/v2/config/util"
 	"github.com/coreos/ignition/v2/config/v3_3/types"
 	old_types "github.com/coreos/ignition/v2/config/v3_4/types"
 	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/util"
 )
 
 // Copy of github.com/coreos/ignition/v2/config/v3_4/translate/translate.go
@@ -46,7 +48,7 @@ func translateFileEmbedded1(old old_types.FileEmbedded1) (ret types.FileEmbedded
 		// the user provides special mode bits in an Ignition config
 		// with the version < 3.4.0, then we need to explicitly mask
 		// those bits out during translation.
-		ret.Mode = util.IntToPtr(*old.Mode & ^07000)
+		ret.Mode = ignutil.IntToPtr(*old.Mode & ^07000)
 	}
 	return
 }
@@ -57,7 +59,7 @@ func translateDirectoryEmbedded1(old old_types.DirectoryEmbedded1) (ret types.Di
 		// the user provides special mode bits in an Ignition config
 		// with the version < 3.4.0, then we need to explicitly mask
 		// those bits out during translation.
-		ret.Mode = util.IntToPtr(*old.Mode & ^07000)
+		ret.Mode = ignutil.IntToPtr(*old.Mode & ^07000)
 	}
 	return
 }
@@ -97,14 +99,38 @@ func translateConfig(old old_types.Config) (ret types.Config) {
 
 // Translate translates Ignition spec config v3.4 to spec v3.3
 func Translate(cfg old_types.Config) (types.Config, error) {
+	res, _, err := checkAndTranslate(cfg, nil)
+	return res, err
+}
+
+// TranslateLossy is like Translate, but drops the fields that cannot be
+// represented in 3.3 instead of failing, and returns every field it dropped.
+func TranslateLossy(cfg old_types.Config) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like Translate, but handles the fields that cannot
+// be represented in 3.3 as policy says, and returns every field it dropped or
+// worked around.
+func TranslateWithPolicy(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+func checkAndTranslate(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Invalid input config:\n%s", rpt.String())
+		return types.Config{}, nil, fmt.Errorf("Invalid input config:\n%s", rpt.String())
 	}
 
-	err := checkValue(reflect.ValueOf(cfg))
-	if err != nil {
-		return types.Config{}, err
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(old_types.Config)
+	}
+	check := util.FeatureCheck{Version: "3.3", Policy: policy}
+	util.Walk(reflect.ValueOf(&cfg).Elem(), "$", func(v reflect.Value, path string) bool {
+		return checkValue(&check, v, path)
+	})
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return types.Config{}, nil, err
 	}
 
 	res := translateConfig(cfg)
@@ -112,81 +138,77 @@ func Translate(cfg old_types.Config) (types.Config, error) {
 	// Sanity check the returned config
 	oldrpt := validate.ValidateWithContext(res, nil)
 	if oldrpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return types.Config{}, nil, fmt.Errorf("Converted spec has unexpected fatal error:\n%s", oldrpt.String())
 	}
-	return res, nil
+	if err := util.CheckTranslation(cfg, res, nil); err != nil {
+		return types.Config{}, nil, err
+	}
+	return res, check.Degraded, nil
 }
 
-func checkValue(v reflect.Value) error {
+// checkValue checks v, which must be addressable, for fields 3.3 cannot
+// represent. It returns false if v itself has to be removed from the config.
+func checkValue(check *util.FeatureCheck, v reflect.Value, path string) bool {
+	keep := true
 	switch v.Type() {
 	case reflect.TypeOf(old_types.Tang{}):
-		tang := v.Interface().(old_types.Tang)
+		tang := v.Addr().Interface().(*old_types.Tang)
 		// 3.3 does not support tang offline provisioning
-		if util.NotEmpty(tang.Advertisement) {
-			return fmt.Errorf("Invalid input config: tang offline provisioning is not supported in spec v3.3")
+		if ignutil.NotEmpty(tang.Advertisement) {
+			check.Unsupported(path+".advertisement", util.FeatureTangAdvertisement, func() {
+				tang.Advertisement = nil
+			})
 		}
 	case reflect.TypeOf(old_types.Luks{}):
-		luks := v.Interface().(old_types.Luks)
+		luks := v.Addr().Interface().(*old_types.Luks)
 		// 3.3 does not support luks discard
-		if util.IsTrue(luks.Discard) {
-			return fmt.Errorf("Invalid input config: luks discard is not supported in spec v3.3")
+		if ignutil.IsTrue(luks.Discard) {
+			check.Unsupported(path+".discard", util.FeatureLuksDiscard, func() {
+				luks.Discard = nil
+			})
 		}
 		// 3.3 does not support luks openOptions
 		if len(luks.OpenOptions) > 0 {
-			return fmt.Errorf("Invalid input config: luks openOptions is not supported in spec v3.3")
+			check.Unsupported(path+".openOptions", util.FeatureLuksOpenOptions, func() {
+				luks.OpenOptions = nil
+			})
 		}
 	case reflect.TypeOf(old_types.FileEmbedded1{}):
-		f := v.Interface().(old_types.FileEmbedded1)
+		f := v.Addr().Interface().(*old_types.FileEmbedded1)
 		// 3.3 does not support special mode bits in files
 		if f.Mode != nil && (*f.Mode&07000) != 0 {
-			return fmt.Errorf("Invalid input config: special mode bits are not supported in spec v3.3")
+			check.Workaround(path+".mode", util.FeatureSpecialModeBits, func() {
+				f.Mode = nil
+			}, func() bool {
+				f.Mode = util.IntPStrict(*f.Mode &^ 07000)
+				return true
+			})
 		}
 	case reflect.TypeOf(old_types.DirectoryEmbedded1{}):
-		d := v.Interface().(old_types.DirectoryEmbedded1)
+		d := v.Addr().Interface().(*old_types.DirectoryEmbedded1)
 		// 3.3 does not support special mode bits in directories
 		if d.Mode != nil && (*d.Mode&07000) != 0 {
-			return fmt.Errorf("Invalid input config: special mode bits are not supported in spec v3.3")
+			check.Workaround(path+".mode", util.FeatureSpecialModeBits, func() {
+				d.Mode = nil
+			}, func() bool {
+				d.Mode = util.IntPStrict(*d.Mode &^ 07000)
+				return true
+			})
 		}
 	case reflect.TypeOf(old_types.Resource{}):
 		resource := v.Interface().(old_types.Resource)
-		// 3.3 does not support arn: scheme for s3
-		if util.NotEmpty(resource.Source) {
+		// 3.3 does not support arn: scheme for s3. A resource cannot do
+		// without its source, so drop and report the resource entirely.
+		if ignutil.NotEmpty(resource.Source) {
 			u, err := url.Parse(*resource.Source)
 			if err != nil {
-				return fmt.Errorf("Invalid input config: %v", err)
-			}
-			if u.Scheme == "arn" {
-				return fmt.Errorf("Invalid input config: arn: scheme for s3 is not supported in spec v3.3")
-			}
-		}
-	}
-	return descend(v)
-}
-
-func descend(v reflect.Value) error {
-	k := v.Type().Kind()
-	switch {
-	case util.IsPrimitive(k):
-		return nil
-	case k == reflect.Struct:
-		for i := 0; i < v.NumField(); i += 1 {
-			err := checkValue(v.Field(i))
-			if err != nil {
-				return err
+				check.Errors.Add(fmt.Errorf("Invalid input config: %v", err))
+			} else if u.Scheme == "arn" {
+				check.Unsupported(path, util.FeatureArnSource, func() {
+					keep = false
+				})
 			}
 		}
-	case k == reflect.Slice:
-		for i := 0; i < v.Len(); i += 1 {
-			err := checkValue(v.Index(i))
-			if err != nil {
-				return err
-			}
-		}
-	case k == reflect.Ptr:
-		v = v.Elem()
-		if v.IsValid() {
-			return checkValue(v)
-		}
 	}
-	return nil
+	return keep
 }
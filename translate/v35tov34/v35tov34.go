@@ -22,6 +22,8 @@ import (
 
 	old_types "github.com/coreos/ignition/v2/config/v3_5/types"
 	"github.com/coreos/ignition/v2/config/validate"
+
+	"github.com/coreos/ign-converter/util"
 )
 
 // Copy of github.com/coreos/ignition/v2/config/v3_5/translate/translate.go
@@ -51,6 +53,7 @@ func translateLuks(old old_types.Luks) (ret types.Luks) {
 }
 func translateTang(old old_types.Tang) (ret types.Tang) {
 	tr := translate.NewTranslator()
+	tr.Translate(&old.Advertisement, &ret.Advertisement)
 	tr.Translate(&old.Thumbprint, &ret.Thumbprint)
 	tr.Translate(&old.URL, &ret.URL)
 	return
@@ -67,14 +70,39 @@ func translateConfig(old old_types.Config) (ret types.Config) {
 
 // Translate translates Ignition spec config v3.5 to spec v3.4
 func Translate(cfg old_types.Config) (types.Config, error) {
+	res, _, err := checkAndTranslate(cfg, nil)
+	return res, err
+}
+
+// TranslateLossy is like Translate, but drops the fields that cannot be
+// represented in 3.4 instead of failing, and returns every field it dropped.
+func TranslateLossy(cfg old_types.Config) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, util.DropPolicy())
+}
+
+// TranslateWithPolicy is like Translate, but handles the fields that cannot
+// be represented in 3.4 as policy says, and returns every field it dropped or
+// worked around.
+func TranslateWithPolicy(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
+	return checkAndTranslate(cfg, policy)
+}
+
+func checkAndTranslate(cfg old_types.Config, policy util.Policy) (types.Config, []util.Degradation, error) {
 	rpt := validate.ValidateWithContext(cfg, nil)
 	if rpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("invalid input config:\n%s", rpt.String())
+		return types.Config{}, nil, fmt.Errorf("invalid input config:\n%s", rpt.String())
 	}
 
-	err := checkValue(reflect.ValueOf(cfg))
-	if err != nil {
-		return types.Config{}, err
+	if !policy.IsStrict() {
+		cfg = util.DeepCopy(cfg).(old_types.Config)
+	}
+	check := util.FeatureCheck{Version: "3.4", Policy: policy}
+	util.Walk(reflect.ValueOf(&cfg).Elem(), "$", func(v reflect.Value, path string) bool {
+		checkValue(&check, v, path)
+		return true
+	})
+	if err := check.Errors.ErrorOrNil(); err != nil {
+		return types.Config{}, nil, err
 	}
 
 	res := translateConfig(cfg)
@@ -82,16 +110,21 @@ func Translate(cfg old_types.Config) (types.Config, error) {
 	// Sanity check the returned config
 	oldrpt := validate.ValidateWithContext(res, nil)
 	if oldrpt.IsFatal() {
-		return types.Config{}, fmt.Errorf("converted spec has unexpected fatal error:\n%s", oldrpt.String())
+		return types.Config{}, nil, fmt.Errorf("converted spec has unexpected fatal error:\n%s", oldrpt.String())
 	}
-	return res, nil
+	if err := util.CheckTranslation(cfg, res, nil); err != nil {
+		return types.Config{}, nil, err
+	}
+	return res, check.Degraded, nil
 }
 
-func checkValue(v reflect.Value) error {
-	// v3.5 introduced Cex type
-	if v.Type() == reflect.TypeOf(old_types.Cex{}) {
-		return fmt.Errorf("invalid input config: 'Cex' type is not supported in spec v3.4")
+// checkValue checks v, which must be addressable, for fields 3.4 cannot
+// represent.
+func checkValue(check *util.FeatureCheck, v reflect.Value, path string) {
+	// v3.5 introduced Cex type. A disabled cex means the same as none.
+	if v.Type() == reflect.TypeOf(old_types.Cex{}) && util.BoolV(v.Interface().(old_types.Cex).Enabled) {
+		check.Unsupported(path, util.FeatureCex, func() {
+			v.Set(reflect.Zero(v.Type()))
+		})
 	}
-
-	return nil
 }
@@ -15,8 +15,14 @@
 package ignconverter
 
 import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/coreos/go-semver/semver"
+	types1 "github.com/coreos/ignition/config/v1/types"
 	types2_2 "github.com/coreos/ignition/config/v2_2/types"
 	types2_3 "github.com/coreos/ignition/config/v2_3/types"
 	types2_4 "github.com/coreos/ignition/config/v2_4/types"
@@ -29,16 +35,22 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/coreos/ign-converter/translate"
+	"github.com/coreos/ign-converter/translate/v1tov30"
 	"github.com/coreos/ign-converter/translate/v23tov30"
 	"github.com/coreos/ign-converter/translate/v24tov31"
 	"github.com/coreos/ign-converter/translate/v30tov22"
 	"github.com/coreos/ign-converter/translate/v31tov22"
 	"github.com/coreos/ign-converter/translate/v31tov24"
+	"github.com/coreos/ign-converter/translate/v31tov30"
 	"github.com/coreos/ign-converter/translate/v32tov22"
 	"github.com/coreos/ign-converter/translate/v32tov24"
 	"github.com/coreos/ign-converter/translate/v32tov31"
+	"github.com/coreos/ign-converter/translate/v33tov24"
 	"github.com/coreos/ign-converter/translate/v33tov32"
+	"github.com/coreos/ign-converter/translate/v34tov24"
 	"github.com/coreos/ign-converter/translate/v34tov33"
+	"github.com/coreos/ign-converter/translate/v35tov24"
 	"github.com/coreos/ign-converter/translate/v35tov34"
 	"github.com/coreos/ign-converter/util"
 )
@@ -2384,6 +2396,11 @@ func TestCheck2_3(t *testing.T) {
 			exhaustiveConfig2_3,
 			nil,
 		},
+		{
+			// need a mountpoint for filesystems with files
+			exhaustiveConfig2_3,
+			map[string]string{"var": ""},
+		},
 	}
 	for i, e := range goodConfigs {
 		if err := v23tov30.Check2_3(e.cfg, e.fsMap); err != nil {
@@ -2416,6 +2433,11 @@ func TestCheck2_4(t *testing.T) {
 			exhaustiveConfig2_4,
 			nil,
 		},
+		{
+			// need a mountpoint for filesystems with files
+			exhaustiveConfig2_4,
+			map[string]string{"var": ""},
+		},
 	}
 	for i, e := range goodConfigs {
 		if err := v24tov31.Check2_4(e.cfg, e.fsMap); err != nil {
@@ -2429,6 +2451,188 @@ func TestCheck2_4(t *testing.T) {
 	}
 }
 
+func TestTranslate1to3_0(t *testing.T) {
+	uid := uint(1001)
+	cfg := types1.Config{
+		Version: 1,
+		Storage: types1.Storage{
+			Filesystems: []types1.Filesystem{
+				{
+					Device: "/dev/sdb1",
+					Format: "xfs",
+					Create: &types1.FilesystemCreate{
+						Force:   true,
+						Options: types1.MkfsOptions{"-L", "data"},
+					},
+					Files: []types1.File{
+						{
+							Path:     "/hello",
+							Contents: "hi",
+							Mode:     0644,
+							Uid:      500,
+						},
+					},
+				},
+				{
+					Device: "/dev/disk/by-label/ROOT",
+					Format: "ext4",
+					Files: []types1.File{
+						{
+							Path:     "/etc/motd",
+							Contents: "welcome",
+							Mode:     0644,
+						},
+					},
+				},
+			},
+		},
+		Passwd: types1.Passwd{
+			Users: []types1.User{
+				{
+					Name: "core",
+					Create: &types1.UserCreate{
+						Uid:    &uid,
+						Groups: []string{"wheel"},
+					},
+				},
+			},
+		},
+	}
+	fsMap := map[string]string{
+		"/dev/sdb1":               "/srv",
+		"/dev/disk/by-label/ROOT": "/",
+	}
+
+	res, err := v1tov30.Translate(cfg, fsMap)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	exp := types3_0.Config{
+		Ignition: types3_0.Ignition{
+			Version: "3.0.0",
+		},
+		Passwd: types3_0.Passwd{
+			Users: []types3_0.PasswdUser{
+				{
+					Name:   "core",
+					UID:    util.IntP(1001),
+					Groups: []types3_0.Group{"wheel"},
+				},
+			},
+		},
+		Storage: types3_0.Storage{
+			Filesystems: []types3_0.Filesystem{
+				{
+					Device:         "/dev/sdb1",
+					Format:         util.StrP("xfs"),
+					WipeFilesystem: util.BoolP(true),
+					Options:        []types3_0.FilesystemOption{"-L", "data"},
+					Path:           util.StrP("/srv"),
+				},
+			},
+			Files: []types3_0.File{
+				{
+					Node: types3_0.Node{
+						Path:      "/srv/hello",
+						Overwrite: util.BoolP(true),
+						User: types3_0.NodeUser{
+							ID: util.IntPStrict(500),
+						},
+						Group: types3_0.NodeGroup{
+							ID: util.IntPStrict(0),
+						},
+					},
+					FileEmbedded1: types3_0.FileEmbedded1{
+						Contents: types3_0.FileContents{
+							Source: util.StrP("data:,hi"),
+						},
+						Mode: util.IntPStrict(0644),
+					},
+				},
+				{
+					Node: types3_0.Node{
+						Path:      "/etc/motd",
+						Overwrite: util.BoolP(true),
+						User: types3_0.NodeUser{
+							ID: util.IntPStrict(0),
+						},
+						Group: types3_0.NodeGroup{
+							ID: util.IntPStrict(0),
+						},
+					},
+					FileEmbedded1: types3_0.FileEmbedded1{
+						Contents: types3_0.FileContents{
+							Source: util.StrP("data:,welcome"),
+						},
+						Mode: util.IntPStrict(0644),
+					},
+				},
+			},
+		},
+	}
+	assert.Equal(t, exp, res)
+
+	// need a mapping for every filesystem
+	err = v1tov30.Check1(cfg, map[string]string{"/dev/sdb1": "/srv"})
+	assert.Equal(t, util.NoFilesystemError{
+		Path: "$.storage.filesystems[1].device",
+		Name: "/dev/disk/by-label/ROOT",
+	}, err)
+
+	// the root filesystem cannot be created
+	cfg.Storage.Filesystems[1].Create = &types1.FilesystemCreate{}
+	err = v1tov30.Check1(cfg, fsMap)
+	assert.Equal(t, util.CreateRootError{
+		Path:   "$.storage.filesystems[1].create",
+		Device: "/dev/disk/by-label/ROOT",
+	}, err)
+	assert.ErrorIs(t, err, util.ErrCreateRoot)
+
+	// partition dimensions in sectors are converted to MiB
+	partitioned := types1.Config{
+		Version: 1,
+		Storage: types1.Storage{
+			Disks: []types1.Disk{
+				{
+					Device: "/dev/sdb",
+					Partitions: []types1.Partition{
+						{
+							Number: 1,
+							Size:   2048,
+						},
+					},
+				},
+			},
+		},
+	}
+	res, err = v1tov30.Translate(partitioned, nil)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, util.IntP(1), res.Storage.Disks[0].Partitions[0].SizeMiB)
+	out, _, err := translate.TranslateConfig(partitioned, types3_0.MaxVersion, translate.Options{SectorSize: 4096})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, util.IntP(8), out.(types3_0.Config).Storage.Disks[0].Partitions[0].SizeMiB)
+	partitioned.Storage.Disks[0].Partitions[0].Size = 1000
+	_, err = v1tov30.Translate(partitioned, nil)
+	assert.Error(t, err)
+
+	err = v1tov30.Check1(types1.Config{
+		Version: 1,
+		Networkd: types1.Networkd{
+			Units: []types1.NetworkdUnit{
+				{
+					Name:     "00-eth0.network",
+					Contents: "[Match]\nName=eth0",
+				},
+			},
+		},
+	}, nil)
+	assert.Equal(t, util.UsesNetworkdError, err)
+}
+
 func TestTranslate2_3to3_0(t *testing.T) {
 	res, err := v23tov30.Translate(exhaustiveConfig2_3, exhaustiveMap)
 	if err != nil {
@@ -2502,6 +2706,104 @@ func TestTranslate3_1to2_4(t *testing.T) {
 	assert.Equal(t, exhaustiveConfig2_4, res)
 }
 
+func TestTranslate3_1to3_0(t *testing.T) {
+	emptyConfig := types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+	}
+
+	_, err := v31tov30.Translate(emptyConfig)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+
+	res, err := v31tov30.Translate(downtranslateConfig3_1)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, downtranslateConfig3_0, res)
+
+	_, err = v31tov30.Translate(nonexhaustiveConfig3_1)
+	assert.Error(t, err)
+
+	_, err = v31tov30.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+			Proxy: types3_1.Proxy{
+				HTTPProxy: util.StrP("https://example.com"),
+			},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = v31tov30.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+			Config: types3_1.IgnitionConfig{
+				Merge: []types3_1.Resource{
+					{
+						Source:      util.StrP("https://example.com"),
+						Compression: util.StrP("gzip"),
+					},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = v31tov30.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+		Storage: types3_1.Storage{
+			Files: []types3_1.File{
+				{
+					Node: types3_1.Node{
+						Path: "/etc/motd",
+					},
+					FileEmbedded1: types3_1.FileEmbedded1{
+						Contents: types3_1.Resource{
+							Source: util.StrP("https://example.com"),
+							HTTPHeaders: types3_1.HTTPHeaders{
+								{
+									Name:  "foo",
+									Value: util.StrP("bar"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = v31tov30.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+		Storage: types3_1.Storage{
+			Files: []types3_1.File{
+				{
+					Node: types3_1.Node{
+						Path: "/etc/motd",
+					},
+					FileEmbedded1: types3_1.FileEmbedded1{
+						Contents: types3_1.Resource{
+							Source: util.StrP("https://example.com"),
+							Verification: types3_1.Verification{
+								Hash: util.StrP("sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+}
+
 func TestTranslate3_2to2_2(t *testing.T) {
 	emptyConfig := types3_2.Config{
 		Ignition: types3_2.Ignition{
@@ -2931,52 +3233,298 @@ func TestTranslate3_5to3_4(t *testing.T) {
 	assert.Error(t, err)
 }
 
-func TestRemoveDuplicateFilesUnitsUsers2_3(t *testing.T) {
-	mode := 420
-	testDataOld := "data:,old"
-	testDataNew := "data:,new"
-	testIgn2Config := types2_3.Config{}
+func TestTranslate3_3to2_4(t *testing.T) {
+	res, err := v33tov24.Translate(nonexhaustiveConfig3_3)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, exhaustiveConfig2_4, res)
 
-	// file test, add a duplicate file and see if the newest one is preserved
-	fileOld := types2_3.File{
-		Node: types2_3.Node{
-			Filesystem: "root", Path: "/etc/testfileconfig",
+	_, err = v33tov24.Translate(types3_3.Config{
+		Ignition: types3_3.Ignition{
+			Version: "3.3.0",
 		},
-		FileEmbedded1: types2_3.FileEmbedded1{
-			Contents: types2_3.FileContents{
-				Source: testDataOld,
+		KernelArguments: types3_3.KernelArguments{
+			ShouldExist: []types3_3.KernelArgument{"foo"},
+		},
+	})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.kernelArguments",
+		Feature: util.FeatureKernelArguments,
+		Version: "2.4",
+	}, err)
+
+	_, err = v33tov24.Translate(types3_3.Config{
+		Ignition: types3_3.Ignition{
+			Version: "3.3.0",
+		},
+		Storage: types3_3.Storage{
+			Luks: []types3_3.Luks{
+				{
+					Name:   "z",
+					Device: util.StrP("/dev/z"),
+				},
 			},
-			Mode: &mode,
 		},
-	}
-	testIgn2Config.Storage.Files = append(testIgn2Config.Storage.Files, fileOld)
+	})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.luks",
+		Feature: util.FeatureLuks,
+		Version: "2.4",
+	}, err)
 
-	fileNew := types2_3.File{
-		Node: types2_3.Node{
-			Filesystem: "root", Path: "/etc/testfileconfig",
+	_, err = v33tov24.Translate(types3_3.Config{
+		Ignition: types3_3.Ignition{
+			Version: "3.3.0",
 		},
-		FileEmbedded1: types2_3.FileEmbedded1{
-			Contents: types2_3.FileContents{
-				Source: testDataNew,
+		KernelArguments: types3_3.KernelArguments{
+			ShouldExist: []types3_3.KernelArgument{"foo"},
+		},
+		Passwd: types3_3.Passwd{
+			Users: []types3_3.PasswdUser{
+				{
+					Name:        "core",
+					ShouldExist: util.BoolPStrict(false),
+				},
 			},
-			Mode: &mode,
 		},
+	})
+	assert.Equal(t, util.Errors{
+		util.UnsupportedFeatureError{
+			Path:    "$.kernelArguments",
+			Feature: util.FeatureKernelArguments,
+			Version: "2.4",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.passwd.users[0].shouldExist",
+			Feature: util.FeatureShouldExist,
+			Version: "2.4",
+		},
+	}, err)
+}
+
+func TestTranslate3_4to2_4(t *testing.T) {
+	expected, err := v33tov24.Translate(downtranslateConfig3_3)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
 	}
-	testIgn2Config.Storage.Files = append(testIgn2Config.Storage.Files, fileNew)
+	res, err := v34tov24.Translate(nonexhaustiveConfig3_4)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, expected, res)
 
-	// unit test, add three units and three dropins with the same name as follows:
-	// unitOne:
-	//    contents: old
-	//    dropin:
-	//        name: one
-	//        contents: old
-	// unitTwo:
-	//    dropin:
-	//        name: one
-	//        contents: new
-	// unitThree:
-	//    contents: new
-	//    dropin:
+	_, err = v34tov24.Translate(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Luks: []types3_4.Luks{
+				{
+					Name:   "z",
+					Device: util.StrP("/dev/sda"),
+					Clevis: types3_4.Clevis{
+						Tang: []types3_4.Tang{
+							{
+								URL:           "http://example.com",
+								Thumbprint:    util.StrP("z"),
+								Advertisement: util.StrP("{\"payload\": \"\", \"protected\": \"\", \"signature\": \"\"}"),
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Error(t, err)
+
+	_, err = v34tov24.Translate(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Directories: []types3_4.Directory{
+				{
+					Node: types3_4.Node{
+						Path: "/rootdir",
+					},
+					DirectoryEmbedded1: types3_4.DirectoryEmbedded1{
+						Mode: util.IntP(01777),
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.directories[0].mode",
+		Feature: util.FeatureSpecialModeBits,
+		Version: "2.4",
+	}, err)
+
+	_, err = v34tov24.Translate(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Files: []types3_4.File{
+				{
+					Node: types3_4.Node{
+						Path: "/path",
+					},
+					FileEmbedded1: types3_4.FileEmbedded1{
+						Contents: types3_4.Resource{
+							Source: util.StrP("arn:aws:s3:us-west-1:123456789012:accesspoint/test/object/some/path"),
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.files[0].contents",
+		Feature: util.FeatureArnSource,
+		Version: "2.4",
+	}, err)
+}
+
+func TestTranslate3_5to2_4(t *testing.T) {
+	expected, err := v34tov24.Translate(downtranslateConfig3_4)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	res, err := v35tov24.Translate(nonexhaustiveConfig3_5)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, expected, res)
+
+	_, err = v35tov24.Translate(types3_5.Config{
+		Ignition: types3_5.Ignition{
+			Version: "3.5.0",
+		},
+		Storage: types3_5.Storage{
+			Luks: []types3_5.Luks{
+				{
+					Name:   "z",
+					Device: util.StrP("/dev/z"),
+					Cex: types3_5.Cex{
+						Enabled: util.BoolP(true),
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.Errors{
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks[0].cex",
+			Feature: util.FeatureCex,
+			Version: "2.4",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks",
+			Feature: util.FeatureLuks,
+			Version: "2.4",
+		},
+	}, err)
+
+	// problems found by every chained stage are reported at once
+	_, err = v35tov24.Translate(types3_5.Config{
+		Ignition: types3_5.Ignition{
+			Version: "3.5.0",
+		},
+		KernelArguments: types3_5.KernelArguments{
+			ShouldExist: []types3_5.KernelArgument{"foo"},
+		},
+		Passwd: types3_5.Passwd{
+			Users: []types3_5.PasswdUser{
+				{
+					Name:        "core",
+					ShouldExist: util.BoolPStrict(false),
+				},
+			},
+		},
+		Storage: types3_5.Storage{
+			Luks: []types3_5.Luks{
+				{
+					Name:   "z",
+					Device: util.StrP("/dev/z"),
+					Cex: types3_5.Cex{
+						Enabled: util.BoolP(true),
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.Errors{
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks[0].cex",
+			Feature: util.FeatureCex,
+			Version: "2.4",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.kernelArguments",
+			Feature: util.FeatureKernelArguments,
+			Version: "2.4",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks",
+			Feature: util.FeatureLuks,
+			Version: "2.4",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.passwd.users[0].shouldExist",
+			Feature: util.FeatureShouldExist,
+			Version: "2.4",
+		},
+	}, err)
+}
+
+func TestRemoveDuplicateFilesUnitsUsers2_3(t *testing.T) {
+	mode := 420
+	testDataOld := "data:,old"
+	testDataNew := "data:,new"
+	testIgn2Config := types2_3.Config{}
+
+	// file test, add a duplicate file and see if the newest one is preserved
+	fileOld := types2_3.File{
+		Node: types2_3.Node{
+			Filesystem: "root", Path: "/etc/testfileconfig",
+		},
+		FileEmbedded1: types2_3.FileEmbedded1{
+			Contents: types2_3.FileContents{
+				Source: testDataOld,
+			},
+			Mode: &mode,
+		},
+	}
+	testIgn2Config.Storage.Files = append(testIgn2Config.Storage.Files, fileOld)
+
+	fileNew := types2_3.File{
+		Node: types2_3.Node{
+			Filesystem: "root", Path: "/etc/testfileconfig",
+		},
+		FileEmbedded1: types2_3.FileEmbedded1{
+			Contents: types2_3.FileContents{
+				Source: testDataNew,
+			},
+			Mode: &mode,
+		},
+	}
+	testIgn2Config.Storage.Files = append(testIgn2Config.Storage.Files, fileNew)
+
+	// unit test, add three units and three dropins with the same name as follows:
+	// unitOne:
+	//    contents: old
+	//    dropin:
+	//        name: one
+	//        contents: old
+	// unitTwo:
+	//    dropin:
+	//        name: one
+	//        contents: new
+	// unitThree:
+	//    contents: new
+	//    dropin:
 	//        name: two
 	//        contents: new
 	// Which should result in:
@@ -3047,7 +3595,7 @@ func TestRemoveDuplicateFilesUnitsUsers2_3(t *testing.T) {
 	}
 	testIgn2Config.Passwd.Users = append(testIgn2Config.Passwd.Users, userOne, userTwo, userThree)
 
-	convertedIgn2Config, err := v23tov30.RemoveDuplicateFilesUnitsUsers(testIgn2Config)
+	convertedIgn2Config, err := v23tov30.RemoveDuplicateFilesUnitsUsers(testIgn2Config, nil)
 	assert.NoError(t, err)
 
 	expectedIgn2Config := types2_3.Config{}
@@ -3188,7 +3736,7 @@ func TestRemoveDuplicateFilesUnitsUsers2_4(t *testing.T) {
 	}
 	testIgn2Config.Passwd.Users = append(testIgn2Config.Passwd.Users, userOne, userTwo, userThree)
 
-	convertedIgn2Config, err := v24tov31.RemoveDuplicateFilesUnitsUsers(testIgn2Config)
+	convertedIgn2Config, err := v24tov31.RemoveDuplicateFilesUnitsUsers(testIgn2Config, nil)
 	assert.NoError(t, err)
 
 	expectedIgn2Config := types2_4.Config{}
@@ -3211,3 +3759,2232 @@ func TestRemoveDuplicateFilesUnitsUsers2_4(t *testing.T) {
 	expectedIgn2Config.Passwd.Users = append(expectedIgn2Config.Passwd.Users, userThree, expectedMergedUser)
 	assert.Equal(t, expectedIgn2Config, convertedIgn2Config)
 }
+
+func TestRemoveDuplicateFilesAppends2_4(t *testing.T) {
+	file := func(fs, path, source string, append bool) types2_4.File {
+		return types2_4.File{
+			Node: types2_4.Node{
+				Filesystem: fs,
+				Path:       path,
+			},
+			FileEmbedded1: types2_4.FileEmbedded1{
+				Append: append,
+				Contents: types2_4.FileContents{
+					Source: source,
+				},
+			},
+		}
+	}
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Storage: types2_4.Storage{
+			Filesystems: []types2_4.Filesystem{
+				{
+					Name: "var",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sdb",
+						Format: "xfs",
+					},
+				},
+			},
+			Files: []types2_4.File{
+				file("root", "/etc/profile.d/env.sh", "data:,dropped", true),
+				file("root", "/etc/profile.d/env.sh", "data:,old", false),
+				file("root", "/etc/profile.d/env.sh", "data:,one", true),
+				file("root", "/var/lib/foo", "data:,old", false),
+				file("root", "/etc/profile.d/env.sh", "data:,new", false),
+				file("root", "/etc/profile.d/env.sh", "data:,two", true),
+				file("var", "/lib/foo", "data:,new", false),
+				file("root", "/etc/profile.d/env.sh", "data:,three", true),
+				file("root", "/etc/motd", "data:,four", true),
+			},
+		},
+	}
+	fsMap := map[string]string{"var": "/var"}
+
+	// duplicate files are rejected, appends too, all of them at once
+	_, err := v24tov31.Translate(cfg, fsMap)
+	assert.IsType(t, util.Errors{}, err)
+	assert.Len(t, err, 6)
+	var dup util.DuplicateInodeError
+	assert.ErrorAs(t, err, &dup)
+
+	_, err = v24tov31.RemoveDuplicateFilesUnitsUsers(cfg, nil)
+	assert.IsType(t, util.NoFilesystemError{}, err)
+
+	res, err := v24tov31.RemoveDuplicateFilesUnitsUsers(cfg, fsMap)
+	if err != nil {
+		t.Fatalf("Failed deduplication: %v", err)
+	}
+	assert.Equal(t, []types2_4.File{
+		file("root", "/etc/motd", "data:,four", true),
+		file("root", "/etc/profile.d/env.sh", "data:,new", false),
+		file("root", "/etc/profile.d/env.sh", "data:,two", true),
+		file("root", "/etc/profile.d/env.sh", "data:,three", true),
+		file("var", "/lib/foo", "data:,new", false),
+	}, res.Storage.Files)
+
+	// appends are only folded into the file they follow on request
+	_, err = v24tov31.Translate(res, fsMap)
+	assert.ErrorIs(t, err, util.ErrDuplicateInode)
+
+	out, report, err := v24tov31.TranslateFoldingAppends(res, fsMap)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Empty(t, report)
+	assert.Equal(t, []types3_1.File{
+		{
+			Node: types3_1.Node{
+				Path:      "/etc/motd",
+				Overwrite: util.BoolPStrict(false),
+			},
+			FileEmbedded1: types3_1.FileEmbedded1{
+				Append: []types3_1.Resource{
+					{Source: util.StrP("data:,four")},
+				},
+			},
+		},
+		{
+			Node: types3_1.Node{
+				Path:      "/etc/profile.d/env.sh",
+				Overwrite: util.BoolPStrict(true),
+			},
+			FileEmbedded1: types3_1.FileEmbedded1{
+				Contents: types3_1.Resource{
+					Source: util.StrP("data:,new"),
+				},
+				Append: []types3_1.Resource{
+					{Source: util.StrP("data:,two")},
+					{Source: util.StrP("data:,three")},
+				},
+			},
+		},
+		{
+			Node: types3_1.Node{
+				Path:      "/var/lib/foo",
+				Overwrite: util.BoolPStrict(true),
+			},
+			FileEmbedded1: types3_1.FileEmbedded1{
+				Contents: types3_1.Resource{
+					Source: util.StrP("data:,new"),
+				},
+			},
+		},
+	}, out.Storage.Files)
+
+	// the attributes of a folded append that differ from the file are reported
+	res.Storage.Files[3].Mode = util.IntP(0600)
+	res.Storage.Files[3].User = &types2_4.NodeUser{Name: "core"}
+	out, report, err = v24tov31.TranslateFoldingAppends(res, fsMap)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Nil(t, out.Storage.Files[1].Mode)
+	assert.Equal(t, []util.Rewrite{
+		{Path: "$.storage.files[3]", Message: "appended to $.storage.files[1], ignoring its mode, user"},
+	}, report)
+
+	_, tr, err := translate.TranslateConfig(res, types3_1.MaxVersion, translate.Options{FsMap: fsMap, RemoveDuplicates: true})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, report, tr.Rewrites)
+}
+
+func TestRemoveDuplicates2_3(t *testing.T) {
+	dir := func(fs, path string, mode int) types2_3.Directory {
+		return types2_3.Directory{
+			Node: types2_3.Node{
+				Filesystem: fs,
+				Path:       path,
+			},
+			DirectoryEmbedded1: types2_3.DirectoryEmbedded1{
+				Mode: util.IntP(mode),
+			},
+		}
+	}
+	link := func(path, target string) types2_3.Link {
+		return types2_3.Link{
+			Node: types2_3.Node{
+				Filesystem: "root",
+				Path:       path,
+			},
+			LinkEmbedded1: types2_3.LinkEmbedded1{
+				Target: target,
+			},
+		}
+	}
+	cfg := types2_3.Config{
+		Ignition: types2_3.Ignition{
+			Version: "2.3.0",
+		},
+		Passwd: types2_3.Passwd{
+			Groups: []types2_3.PasswdGroup{
+				{Name: "docker", Gid: util.IntP(1000)},
+				{Name: "wheel"},
+				{Name: "docker", Gid: util.IntP(1001)},
+			},
+		},
+		Storage: types2_3.Storage{
+			Directories: []types2_3.Directory{
+				dir("root", "/var/lib/foo", 0700),
+				dir("root", "/etc/foo", 0755),
+				dir("var", "/lib/foo", 0750),
+			},
+			Links: []types2_3.Link{
+				link("/etc/localtime", "/usr/share/zoneinfo/UTC"),
+				link("/etc/localtime", "/usr/share/zoneinfo/Europe/Berlin"),
+			},
+		},
+		Systemd: types2_3.Systemd{
+			Units: []types2_3.Unit{
+				{
+					Name:     "foo.service",
+					Contents: "[Service]\nExecStart=/bin/true\n",
+					Dropins: []types2_3.SystemdDropin{
+						{Name: "a.conf", Contents: "[Service]\n"},
+					},
+				},
+				{
+					Name: "foo.service",
+					Dropins: []types2_3.SystemdDropin{
+						{Name: "a.conf", Contents: "[Service]\nUser=core\n"},
+					},
+				},
+			},
+		},
+	}
+	fsMap := map[string]string{"var": "/var"}
+
+	_, err := v23tov30.Translate(cfg, fsMap)
+	assert.Error(t, err)
+
+	res, report, err := v23tov30.RemoveDuplicates(cfg, fsMap)
+	if err != nil {
+		t.Fatalf("Failed deduplication: %v", err)
+	}
+	assert.Equal(t, []types2_3.Directory{
+		dir("root", "/etc/foo", 0755),
+		dir("var", "/lib/foo", 0750),
+	}, res.Storage.Directories)
+	assert.Equal(t, []types2_3.Link{
+		link("/etc/localtime", "/usr/share/zoneinfo/Europe/Berlin"),
+	}, res.Storage.Links)
+	assert.Equal(t, []types2_3.PasswdGroup{
+		{Name: "wheel"},
+		{Name: "docker", Gid: util.IntP(1001)},
+	}, res.Passwd.Groups)
+	assert.Equal(t, []types2_3.Unit{
+		{
+			Name:     "foo.service",
+			Contents: "[Service]\nExecStart=/bin/true\n",
+			Dropins: []types2_3.SystemdDropin{
+				{Name: "a.conf", Contents: "[Service]\nUser=core\n"},
+			},
+		},
+	}, res.Systemd.Units)
+	assert.Equal(t, []util.Rewrite{
+		{Path: "$.storage.directories[0]", Message: "discarded, duplicate of $.storage.directories[2]"},
+		{Path: "$.storage.links[0]", Message: "discarded, duplicate of $.storage.links[1]"},
+		{Path: "$.systemd.units[0]", Message: "merged into $.systemd.units[1]"},
+		{Path: "$.systemd.units[0].dropins[0]", Message: "discarded, overridden by a dropin in $.systemd.units[1]"},
+		{Path: "$.passwd.groups[0]", Message: "discarded, duplicate of $.passwd.groups[2]"},
+	}, report)
+
+	_, err = v23tov30.Translate(res, fsMap)
+	assert.NoError(t, err)
+
+	_, tr, err := translate.TranslateConfig(cfg, types3_0.MaxVersion, translate.Options{FsMap: fsMap, RemoveDuplicates: true})
+	assert.NoError(t, err)
+	assert.Equal(t, report, tr.Rewrites)
+}
+
+func TestDetectVersion(t *testing.T) {
+	v, err := translate.DetectVersion([]byte(`{"ignition": {"version": "3.4.0"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, types3_4.MaxVersion, v)
+
+	v, err = translate.DetectVersion([]byte(`{"ignitionVersion": 1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, types1.MaxVersion, v)
+
+	_, err = translate.DetectVersion([]byte(`{"ignition": {}}`))
+	assert.Error(t, err)
+	_, err = translate.DetectVersion([]byte(`{"ignition": {"version": "foo"}}`))
+	assert.Error(t, err)
+	_, err = translate.DetectVersion([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestTranslateChain(t *testing.T) {
+	raw := []byte(`{"ignition": {"version": "3.5.0"}, "storage": {"files": [{"path": "/etc/motd", "contents": {"source": "data:,hello"}}]}}`)
+	res, tr, err := translate.Translate(raw, types2_2.MaxVersion, translate.Options{})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []semver.Version{
+		types3_5.MaxVersion,
+		types3_4.MaxVersion,
+		types3_3.MaxVersion,
+		types3_2.MaxVersion,
+		types2_2.MaxVersion,
+	}, tr.Path)
+	cfg := res.(types2_2.Config)
+	assert.Equal(t, "2.2.0", cfg.Ignition.Version)
+	assert.Equal(t, "/etc/motd", cfg.Storage.Files[0].Path)
+	assert.Equal(t, "data:,hello", cfg.Storage.Files[0].Contents.Source)
+
+	// direct edges are preferred over longer chains
+	_, tr, err = translate.Translate(raw, types2_4.MaxVersion, translate.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []semver.Version{types3_5.MaxVersion, types2_4.MaxVersion}, tr.Path)
+
+	// upward through Ignition's own translators
+	res, tr, err = translate.TranslateConfig(exhaustiveConfig2_4, types3_5.MaxVersion, translate.Options{FsMap: exhaustiveMap})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []semver.Version{
+		types2_4.MaxVersion,
+		types3_1.MaxVersion,
+		types3_2.MaxVersion,
+		types3_3.MaxVersion,
+		types3_4.MaxVersion,
+		types3_5.MaxVersion,
+	}, tr.Path)
+	assert.Equal(t, "3.5.0", res.(types3_5.Config).Ignition.Version)
+
+	// no-op translation
+	res, tr, err = translate.TranslateConfig(nonexhaustiveConfig3_5, types3_5.MaxVersion, translate.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, []semver.Version{types3_5.MaxVersion}, tr.Path)
+	assert.Equal(t, nonexhaustiveConfig3_5, res)
+
+	// errors from a hop are passed through
+	_, _, err = translate.TranslateConfig(exhaustiveConfig2_4, types3_1.MaxVersion, translate.Options{})
+	assert.Error(t, err)
+
+	_, err = translate.FindPath(types2_4.MaxVersion, semver.Version{Major: 1})
+	assert.Error(t, err)
+}
+
+func TestTranslatePartitionWithoutWipe(t *testing.T) {
+	// wipePartitionEntry is optional in spec 3
+	for _, version := range []string{"3.0.0", "3.1.0", "3.2.0", "3.3.0", "3.4.0", "3.5.0"} {
+		raw := []byte(`{"ignition": {"version": "` + version + `"}, "storage": {"disks": [{"device": "/dev/sda", "partitions": [{"number": 1, "sizeMiB": 100}]}]}}`)
+		res, _, err := translate.Translate(raw, types2_4.MaxVersion, translate.Options{})
+		if err != nil {
+			t.Fatalf("Failed translation of %s config: %v", version, err)
+		}
+		cfg := res.(types2_4.Config)
+		assert.Equal(t, []types2_4.Partition{
+			{
+				Number:  1,
+				SizeMiB: util.IntP(100),
+			},
+		}, cfg.Storage.Disks[0].Partitions, version)
+	}
+}
+
+func TestTranslateOldSpec2(t *testing.T) {
+	for _, version := range []string{"2.0.0", "2.1.0", "2.2.0"} {
+		raw := []byte(`{"ignition": {"version": "` + version + `"}, "storage": {"files": [{"filesystem": "root", "path": "/etc/motd", "contents": {"source": "data:,hello"}}]}}`)
+		res, tr, err := translate.Translate(raw, types3_1.MaxVersion, translate.Options{})
+		if err != nil {
+			t.Fatalf("Failed translation of %s config: %v", version, err)
+		}
+		assert.Equal(t, version, tr.Path[0].String())
+		cfg := res.(types3_1.Config)
+		assert.Equal(t, "3.1.0", cfg.Ignition.Version)
+		assert.Equal(t, "/etc/motd", cfg.Storage.Files[0].Path)
+		assert.Equal(t, "data:,hello", *cfg.Storage.Files[0].Contents.Source)
+	}
+
+	// non-root filesystems still need a mapping
+	raw := []byte(`{"ignition": {"version": "2.1.0"}, "storage": {"filesystems": [{"name": "var", "mount": {"device": "/dev/sdb", "format": "xfs"}}], "files": [{"filesystem": "var", "path": "/motd", "contents": {"source": "data:,hello"}}]}}`)
+	_, _, err := translate.Translate(raw, types3_0.MaxVersion, translate.Options{})
+	assert.Error(t, err)
+	_, _, err = translate.Translate(raw, types3_0.MaxVersion, translate.Options{FsMap: map[string]string{"var": ""}})
+	var noMountpoint util.NoMountpointError
+	if assert.ErrorAs(t, err, &noMountpoint) {
+		assert.Equal(t, "$.storage.files[0].filesystem", noMountpoint.Path)
+	}
+	res, _, err := translate.Translate(raw, types3_0.MaxVersion, translate.Options{FsMap: map[string]string{"var": "/var"}})
+	assert.NoError(t, err)
+	assert.Equal(t, "/var/motd", res.(types3_0.Config).Storage.Files[0].Path)
+}
+
+func TestTranslateFsMapRoundTrip(t *testing.T) {
+	res, fsMap, err := v32tov24.TranslateWithMapping(nonexhaustiveConfig3_2)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, exhaustiveConfig2_4, res)
+	assert.Equal(t, map[string]string{"/var": "/var"}, fsMap)
+
+	// the mapping is enough to translate the result back
+	back, err := v24tov31.Translate(res, fsMap)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, nonexhaustiveConfig3_1, back)
+
+	_, tr, err := translate.TranslateConfig(nonexhaustiveConfig3_5, types2_4.MaxVersion, translate.Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"/var": "/var"}, tr.FsMap)
+	_, tr, err = translate.TranslateConfig(nonexhaustiveConfig3_5, types3_3.MaxVersion, translate.Options{})
+	assert.NoError(t, err)
+	assert.Nil(t, tr.FsMap)
+}
+
+func TestParseFsMap(t *testing.T) {
+	want := map[string]string{"var": "/var", "home": "/home", "swap": ""}
+	for _, in := range []string{
+		"var /var\nhome /home\nswap",
+		"# comment\n\nvar\t/var/\n  home   /home  \n\nswap\n",
+		`{"var": "/var", "home": "/home", "swap": ""}`,
+		"# comment\nvar: /var\nhome: /home\nswap: \"\"\n",
+	} {
+		m, warnings, err := util.ParseFsMap([]byte(in))
+		if assert.NoError(t, err, in) {
+			assert.Equal(t, want, m, in)
+			assert.Empty(t, warnings, in)
+		}
+	}
+
+	m, _, err := util.ParseFsMap(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{}, m)
+
+	_, warnings, err := util.ParseFsMap([]byte("var /var\nlog /var/log\n"))
+	assert.NoError(t, err)
+	assert.Len(t, warnings, 1)
+
+	for _, in := range []string{
+		"var var",
+		"var /var extra",
+		"var /var\nvar /srv",
+		"var /var\nother /var/",
+		`{"var": "var"}`,
+		"var: [/var]",
+	} {
+		_, _, err := util.ParseFsMap([]byte(in))
+		assert.Error(t, err, in)
+	}
+
+	// text lines whose name ends in a colon are still text, as are YAML
+	// objects whose values are all absolute paths
+	m, _, err = util.ParseFsMap([]byte("var: /var\ndata: /data\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"var:": "/var", "data:": "/data"}, m)
+	m, _, err = util.ParseFsMap([]byte("data: /data\nswap: \"\"\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"data": "/data", "swap": ""}, m)
+	m, _, err = util.ParseFsMap([]byte(`{"data:": "/data"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"data:": "/data"}, m)
+	_, _, err = util.ParseFsMap([]byte("var /var\nhome home\n"))
+	assert.EqualError(t, err, `line 2: filesystem "home" is mapped to "home", which is not an absolute path`)
+
+	// the written mapping reads back unchanged
+	m, _, err = util.ParseFsMap(util.FormatFsMap(want))
+	assert.NoError(t, err)
+	assert.Equal(t, want, m)
+}
+
+func TestInferFsMap(t *testing.T) {
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Storage: types2_4.Storage{
+			Filesystems: []types2_4.Filesystem{
+				{
+					Name: "data",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sdb",
+						Format: "xfs",
+						Label:  util.StrP("DATA"),
+					},
+				},
+				{
+					Name: "var",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sdc",
+						Format: "xfs",
+						Label:  util.StrP("var"),
+					},
+				},
+				{
+					Name: "given",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sdd",
+						Format: "xfs",
+						Label:  util.StrP("home"),
+					},
+				},
+				{
+					Name: "unknown",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sde",
+						Format: "xfs",
+					},
+				},
+			},
+		},
+		Systemd: types2_4.Systemd{
+			Units: []types2_4.Unit{
+				{
+					Name:     "srv-data.mount",
+					Contents: "[Mount]\nWhat=/dev/disk/by-label/DATA\nWhere=/srv\n",
+					Dropins: []types2_4.SystemdDropin{
+						{
+							Name:     "where.conf",
+							Contents: "[Mount]\nWhere=/srv/data\n",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fsMap, report := v24tov31.InferFsMap(cfg, map[string]string{"given": "/srv/given"})
+	assert.Equal(t, map[string]string{
+		"data":  "/srv/data",
+		"var":   "/var",
+		"given": "/srv/given",
+	}, fsMap)
+	assert.Equal(t, []util.InferredPath{
+		{Filesystem: "data", Path: "/srv/data", Source: util.FsMapSourceMountUnit, Detail: "srv-data.mount"},
+		{Filesystem: "var", Path: "/var", Source: util.FsMapSourceLabel, Detail: "var"},
+		{Filesystem: "given", Path: "/srv/given", Source: util.FsMapSourceGiven},
+	}, report)
+
+	// filesystems that cannot be inferred still need a mapping
+	_, _, err := translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{InferFsMap: true})
+	assert.Error(t, err)
+	cfg.Storage.Filesystems = cfg.Storage.Filesystems[:2]
+	res, tr, err := translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{InferFsMap: true})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Len(t, tr.InferredFsMap, 2)
+	assert.Equal(t, "/srv/data", *res.(types3_1.Config).Storage.Filesystems[0].Path)
+
+	// inference is opt-in
+	_, _, err = translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{})
+	assert.Error(t, err)
+}
+
+func TestResolveFilesystem(t *testing.T) {
+	fss := []string{"/var", "/var/lib/containers/", "/srv/data"}
+	tests := []struct {
+		in  string
+		fs  string
+		rel string
+	}{
+		{"/etc/motd", "root", "/etc/motd"},
+		{"/var/log/messages", "/var", "/log/messages"},
+		{"/variable/file", "root", "/variable/file"},
+		{"/var", "/var", "/"},
+		{"/var/", "/var", "/"},
+		{"/var/lib/containers/storage", "/var/lib/containers/", "/storage"},
+		{"/var/lib/containers", "/var/lib/containers/", "/"},
+		{"/var/lib/containersfoo", "/var", "/lib/containersfoo"},
+		{"/srv/data/dir/", "/srv/data", "/dir"},
+		{"/srv/database", "root", "/srv/database"},
+	}
+	for _, test := range tests {
+		fs, rel := util.ResolveFilesystem(test.in, fss)
+		assert.Equal(t, test.fs, fs, test.in)
+		assert.Equal(t, test.rel, rel, test.in)
+	}
+
+	// a filesystem mounted at / takes everything not on a deeper mountpoint
+	fs, rel := util.ResolveFilesystem("/etc/motd", []string{"/", "/var"})
+	assert.Equal(t, "/", fs)
+	assert.Equal(t, "/etc/motd", rel)
+	fs, rel = util.ResolveFilesystem("/var/motd", []string{"/", "/var"})
+	assert.Equal(t, "/var", fs)
+	assert.Equal(t, "/motd", rel)
+
+	cfg := types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Storage: types3_2.Storage{
+			Filesystems: []types3_2.Filesystem{
+				{
+					Device: "/dev/sdb",
+					Format: util.StrP("xfs"),
+					Path:   util.StrP("/var"),
+				},
+			},
+			Directories: []types3_2.Directory{
+				{
+					Node: types3_2.Node{
+						Path: "/variable",
+					},
+				},
+				{
+					Node: types3_2.Node{
+						Path: "/var/lib",
+					},
+				},
+			},
+		},
+	}
+	res, err := v32tov24.Translate(cfg)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, "root", res.Storage.Directories[0].Filesystem)
+	assert.Equal(t, "/variable", res.Storage.Directories[0].Path)
+	assert.Equal(t, "/var", res.Storage.Directories[1].Filesystem)
+	assert.Equal(t, "/lib", res.Storage.Directories[1].Path)
+}
+
+func TestTranslateNetworkd(t *testing.T) {
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Networkd: types2_4.Networkd{
+			Units: []types2_4.Networkdunit{
+				{
+					Name:     "00-eth0.network",
+					Contents: "[Match]\nName=eth0\n",
+					Dropins: []types2_4.NetworkdDropin{
+						{
+							Name:     "dhcp.conf",
+							Contents: "[Network]\nDHCP=yes\n",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, err := v24tov31.Translate(cfg, nil)
+	assert.Equal(t, util.UsesNetworkdError, err)
+
+	next, err := v24tov31.TranslateNetworkd(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	res, err := v24tov31.Translate(next, nil)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, types3_1.Storage{
+		Directories: []types3_1.Directory{
+			{
+				Node: types3_1.Node{
+					Path: "/etc/systemd/network/00-eth0.network.d",
+				},
+				DirectoryEmbedded1: types3_1.DirectoryEmbedded1{
+					Mode: util.IntP(0755),
+				},
+			},
+		},
+		Files: []types3_1.File{
+			{
+				Node: types3_1.Node{
+					Path:      "/etc/systemd/network/00-eth0.network",
+					Overwrite: util.BoolPStrict(true),
+				},
+				FileEmbedded1: types3_1.FileEmbedded1{
+					Contents: types3_1.Resource{
+						Source: util.StrP("data:,%5BMatch%5D%0AName%3Deth0%0A"),
+					},
+					Mode: util.IntP(0644),
+				},
+			},
+			{
+				Node: types3_1.Node{
+					Path:      "/etc/systemd/network/00-eth0.network.d/dhcp.conf",
+					Overwrite: util.BoolPStrict(true),
+				},
+				FileEmbedded1: types3_1.FileEmbedded1{
+					Contents: types3_1.Resource{
+						Source: util.StrP("data:,%5BNetwork%5D%0ADHCP%3Dyes%0A"),
+					},
+					Mode: util.IntP(0644),
+				},
+			},
+		},
+	}, res.Storage)
+
+	// files already in the config are not overwritten
+	cfg.Storage.Files = []types2_4.File{
+		{
+			Node: types2_4.Node{
+				Filesystem: "etc",
+				Path:       "/systemd/network/00-eth0.network",
+			},
+		},
+	}
+	_, err = v24tov31.TranslateNetworkd(cfg, map[string]string{"etc": "/etc"})
+	assert.IsType(t, util.NetworkdConflictError{}, err)
+
+	cfg.Storage.Files = nil
+	cfg.Storage.Links = []types2_4.Link{
+		{
+			Node: types2_4.Node{
+				Filesystem: "root",
+				Path:       "/etc/systemd/network/00-eth0.network.d",
+			},
+			LinkEmbedded1: types2_4.LinkEmbedded1{
+				Target: "/dev/null",
+			},
+		},
+	}
+	_, err = v24tov31.TranslateNetworkd(cfg, nil)
+	assert.IsType(t, util.NetworkdConflictError{}, err)
+
+	// through the translate package
+	cfg.Storage.Links = nil
+	_, _, err = translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{})
+	assert.Error(t, err)
+	_, _, err = translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{NetworkdToFiles: true})
+	assert.NoError(t, err)
+}
+
+func TestFilesToNetworkd(t *testing.T) {
+	cfg := types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Storage: types3_2.Storage{
+			Files: []types3_2.File{
+				{
+					Node: types3_2.Node{
+						Path:      "/etc/systemd/network/00-eth0.network.d/dhcp.conf",
+						Overwrite: util.BoolPStrict(true),
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("data:,%5BNetwork%5D%0ADHCP%3Dyes%0A"),
+						},
+						Mode: util.IntP(0644),
+					},
+				},
+				{
+					Node: types3_2.Node{
+						Path:      "/etc/systemd/network/00-eth0.network",
+						Overwrite: util.BoolPStrict(true),
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("data:,%5BMatch%5D%0AName%3Deth0%0A"),
+						},
+					},
+				},
+				{
+					Node: types3_2.Node{
+						Path: "/etc/systemd/network/10-eth1.network",
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("https://example.com/10-eth1.network"),
+						},
+					},
+				},
+				{
+					Node: types3_2.Node{
+						Path: "/etc/systemd/network/20-eth2.network",
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("data:,"),
+						},
+						Mode: util.IntP(0600),
+					},
+				},
+				{
+					// networkd units always replace existing files
+					Node: types3_2.Node{
+						Path: "/etc/systemd/network/30-eth3.network",
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("data:,"),
+						},
+					},
+				},
+				{
+					Node: types3_2.Node{
+						Path: "/etc/systemd/network/README",
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Contents: types3_2.Resource{
+							Source: util.StrP("data:,"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	res, err := v32tov24.Translate(cfg)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	res = v32tov24.FilesToNetworkd(res)
+	assert.Equal(t, []types2_4.Networkdunit{
+		{
+			Name:     "00-eth0.network",
+			Contents: "[Match]\nName=eth0\n",
+			Dropins: []types2_4.NetworkdDropin{
+				{
+					Name:     "dhcp.conf",
+					Contents: "[Network]\nDHCP=yes\n",
+				},
+			},
+		},
+	}, res.Networkd.Units)
+	var paths []string
+	for _, f := range res.Storage.Files {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{
+		"/etc/systemd/network/10-eth1.network",
+		"/etc/systemd/network/20-eth2.network",
+		"/etc/systemd/network/30-eth3.network",
+		"/etc/systemd/network/README",
+	}, paths)
+
+	// the networkd section survives the round trip
+	back, err := v24tov31.TranslateNetworkd(res, nil)
+	assert.NoError(t, err)
+	assert.Len(t, back.Storage.Files, 6)
+
+	out, _, err := translate.TranslateConfig(cfg, types2_2.MaxVersion, translate.Options{NetworkdFromFiles: true})
+	assert.NoError(t, err)
+	assert.Len(t, out.(types2_2.Config).Networkd.Units, 1)
+	out, _, err = translate.TranslateConfig(cfg, types2_2.MaxVersion, translate.Options{})
+	assert.NoError(t, err)
+	assert.Empty(t, out.(types2_2.Config).Networkd.Units)
+}
+
+func TestMigrateDeprecated(t *testing.T) {
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Passwd: types2_4.Passwd{
+			Users: []types2_4.PasswdUser{
+				{
+					Name: "core",
+					Create: &types2_4.Usercreate{
+						UID:    util.IntP(1001),
+						Groups: []types2_4.UsercreateGroup{"wheel"},
+						Shell:  "/bin/zsh",
+					},
+				},
+			},
+		},
+		Storage: types2_4.Storage{
+			Disks: []types2_4.Disk{
+				{
+					Device: "/dev/sda",
+					Partitions: []types2_4.Partition{
+						{
+							Number: 1,
+							Size:   util.IntPStrict(4096),
+							Start:  util.IntPStrict(0),
+						},
+					},
+				},
+			},
+			Filesystems: []types2_4.Filesystem{
+				{
+					Name: "var",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sda1",
+						Format: "xfs",
+						Create: &types2_4.Create{
+							Force:   true,
+							Options: []types2_4.CreateOption{"-m", "0"},
+						},
+					},
+				},
+			},
+		},
+		Systemd: types2_4.Systemd{
+			Units: []types2_4.Unit{
+				{
+					Name:   "foo.service",
+					Enable: true,
+				},
+			},
+		},
+	}
+
+	_, err := v24tov31.Translate(cfg, exhaustiveMap)
+	assert.Error(t, err)
+
+	res, rewrites, err := v24tov31.MigrateDeprecated(cfg, 0)
+	if err != nil {
+		t.Fatalf("Failed migration: %v", err)
+	}
+	assert.Equal(t, []util.Rewrite{
+		{Path: "$.passwd.users[0].create", Message: "moved to the top-level user fields"},
+		{Path: "$.storage.filesystems[0].mount.create", Message: "moved to wipeFilesystem and options"},
+		{Path: "$.storage.disks[0].partitions[0].size", Message: "4096 sectors of 512 bytes converted to sizeMiB"},
+		{Path: "$.storage.disks[0].partitions[0].start", Message: "dropped, 0 sectors is the default"},
+		{Path: "$.systemd.units[0].enable", Message: "moved to enabled"},
+	}, rewrites)
+	assert.Equal(t, types2_4.PasswdUser{
+		Name:   "core",
+		UID:    util.IntP(1001),
+		Groups: []types2_4.Group{"wheel"},
+		Shell:  "/bin/zsh",
+	}, res.Passwd.Users[0])
+	assert.Equal(t, &types2_4.Mount{
+		Device:         "/dev/sda1",
+		Format:         "xfs",
+		WipeFilesystem: true,
+		Options:        []types2_4.MountOption{"-m", "0"},
+	}, res.Storage.Filesystems[0].Mount)
+	assert.Equal(t, types2_4.Partition{
+		Number:  1,
+		SizeMiB: util.IntP(2),
+	}, res.Storage.Disks[0].Partitions[0])
+	assert.Equal(t, util.BoolPStrict(true), res.Systemd.Units[0].Enabled)
+	assert.False(t, res.Systemd.Units[0].Enable)
+	// the input is not modified
+	assert.NotNil(t, cfg.Passwd.Users[0].Create)
+	assert.NotNil(t, cfg.Storage.Filesystems[0].Mount.Create)
+
+	_, err = v24tov31.Translate(res, exhaustiveMap)
+	assert.NoError(t, err)
+
+	// fields the user sets already are kept, and must agree with create
+	user := cfg.Passwd.Users[0]
+	cfg.Passwd.Users[0].Shell = "/bin/zsh"
+	cfg.Passwd.Users[0].HomeDir = "/home/core"
+	cfg.Passwd.Users[0].Groups = []types2_4.Group{"wheel", "docker"}
+	res, _, err = v24tov31.MigrateDeprecated(cfg, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, types2_4.PasswdUser{
+		Name:    "core",
+		UID:     util.IntP(1001),
+		Groups:  []types2_4.Group{"wheel", "docker"},
+		HomeDir: "/home/core",
+		Shell:   "/bin/zsh",
+	}, res.Passwd.Users[0])
+
+	cfg.Passwd.Users[0].UID = util.IntP(1000)
+	cfg.Passwd.Users[0].Shell = "/bin/bash"
+	_, _, err = v24tov31.MigrateDeprecated(cfg, 0)
+	assert.Equal(t, util.Errors{
+		util.CreateConflictError{Path: "$.passwd.users[0].create.uid"},
+		util.CreateConflictError{Path: "$.passwd.users[0].create.shell"},
+	}, err)
+	assert.ErrorIs(t, err, util.ErrCreateConflict)
+	cfg.Passwd.Users[0] = user
+
+	_, _, err = v23tov30.MigrateDeprecated(types2_3.Config{
+		Ignition: types2_3.Ignition{
+			Version: "2.3.0",
+		},
+		Passwd: types2_3.Passwd{
+			Users: []types2_3.PasswdUser{
+				{
+					Name:  "core",
+					Gecos: "Core",
+					Create: &types2_3.Usercreate{
+						Gecos: "CoreOS",
+					},
+				},
+			},
+		},
+	}, 0)
+	assert.Equal(t, util.CreateConflictError{Path: "$.passwd.users[0].create.gecos"}, err)
+
+	// with 4096 byte sectors
+	res, _, err = v24tov31.MigrateDeprecated(cfg, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, util.IntP(16), res.Storage.Disks[0].Partitions[0].SizeMiB)
+
+	// sizes that are not whole MiB cannot be migrated
+	cfg.Storage.Disks[0].Partitions[0].Size = util.IntPStrict(1000)
+	_, _, err = v24tov31.MigrateDeprecated(cfg, 0)
+	assert.Error(t, err)
+
+	cfg.Storage.Disks[0].Partitions[0].Size = nil
+	_, tr, err := translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{FsMap: exhaustiveMap, MigrateDeprecated: true})
+	assert.NoError(t, err)
+	assert.Len(t, tr.Rewrites, 4)
+}
+
+func TestTranslateSectorSize(t *testing.T) {
+	cfg := types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Storage: types3_2.Storage{
+			Disks: []types3_2.Disk{
+				{
+					Device: "/dev/sda",
+					Partitions: []types3_2.Partition{
+						{
+							Number:   1,
+							Label:    util.StrP("one"),
+							SizeMiB:  util.IntP(100),
+							StartMiB: util.IntPStrict(0),
+						},
+						{
+							Number:   2,
+							Label:    util.StrP("two"),
+							StartMiB: util.IntP(200),
+							SizeMiB:  util.IntPStrict(0),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	res, err := v32tov22.Translate(cfg)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []types2_2.Partition{
+		{Number: 1, Label: "one", Size: 204800},
+		{Number: 2, Label: "two", Start: 409600},
+	}, res.Storage.Disks[0].Partitions)
+
+	res, _, err = v32tov22.TranslateWithSectorSize(cfg, 4096)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []types2_2.Partition{
+		{Number: 1, Label: "one", Size: 25600},
+		{Number: 2, Label: "two", Start: 51200},
+	}, res.Storage.Disks[0].Partitions)
+
+	_, _, err = v32tov22.TranslateWithSectorSize(cfg, 1000)
+	assert.Error(t, err)
+
+	// the sectors are migrated back with the same sector size
+	out, _, err := translate.TranslateConfig(cfg, types2_2.MaxVersion, translate.Options{SectorSize: 4096})
+	assert.NoError(t, err)
+	assert.Equal(t, res, out)
+	back, _, err := translate.TranslateConfig(out, types3_2.MaxVersion, translate.Options{SectorSize: 4096, MigrateDeprecated: true})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, util.IntP(100), back.(types3_2.Config).Storage.Disks[0].Partitions[0].SizeMiB)
+	assert.Equal(t, util.IntP(200), back.(types3_2.Config).Storage.Disks[0].Partitions[1].StartMiB)
+}
+
+func TestCheckPathUsesLink(t *testing.T) {
+	links := []string{"/opt/bin"}
+	assert.Equal(t, "/opt/bin", util.CheckPathUsesLink(links, "/opt/bin/tool"))
+	assert.Equal(t, "", util.CheckPathUsesLink(links, "/opt/bin"))
+	assert.Equal(t, "", util.CheckPathUsesLink(links, "/opt/binary"))
+	assert.Equal(t, "", util.CheckPathUsesLink(links, "/opt/binary/tool"))
+
+	// a path sharing a prefix with a link does not go through it
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Storage: types2_4.Storage{
+			Links: []types2_4.Link{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/opt/bin",
+					},
+					LinkEmbedded1: types2_4.LinkEmbedded1{
+						Target: "/usr/local/bin",
+					},
+				},
+			},
+			Files: []types2_4.File{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/opt/binary/tool",
+					},
+					FileEmbedded1: types2_4.FileEmbedded1{
+						Contents: types2_4.FileContents{
+							Source: "data:,",
+						},
+					},
+				},
+			},
+		},
+	}
+	assert.NoError(t, v24tov31.Check2_4(cfg, nil))
+	cfg.Storage.Files[0].Path = "/opt/bin/tool"
+	assert.IsType(t, util.UsesOwnLinkError{}, v24tov31.Check2_4(cfg, nil))
+
+	cfg2_3 := types2_3.Config{
+		Ignition: types2_3.Ignition{
+			Version: "2.3.0",
+		},
+		Storage: types2_3.Storage{
+			Links: []types2_3.Link{
+				{
+					Node: types2_3.Node{
+						Filesystem: "root",
+						Path:       "/opt/bin",
+					},
+					LinkEmbedded1: types2_3.LinkEmbedded1{
+						Target: "/usr/local/bin",
+					},
+				},
+			},
+			Directories: []types2_3.Directory{
+				{
+					Node: types2_3.Node{
+						Filesystem: "root",
+						Path:       "/opt/binary",
+					},
+				},
+			},
+		},
+	}
+	assert.NoError(t, v23tov30.Check2_3(cfg2_3, nil))
+}
+
+func TestResolveLinks(t *testing.T) {
+	links := map[string]string{
+		"/opt/bin":       "/usr/local/bin",
+		"/etc/alt":       "../srv/alt",
+		"/usr/local/lib": "/opt/bin/lib",
+		"/loop/a":        "/loop/b",
+		"/loop/b":        "a",
+		"/nest":          "/nest/sub",
+	}
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"/opt/bin/tool", "/usr/local/bin/tool"},
+		{"/opt/binary", "/opt/binary"},
+		{"/opt/bin", "/opt/bin"},
+		{"/etc/alt/conf", "/srv/alt/conf"},
+		{"/usr/local/lib/x/y", "/usr/local/bin/lib/x/y"},
+	}
+	for _, test := range tests {
+		out, err := util.ResolveLinks(test.in, links)
+		assert.NoError(t, err, test.in)
+		assert.Equal(t, test.out, out, test.in)
+	}
+	_, err := util.ResolveLinks("/loop/a/file", links)
+	assert.IsType(t, util.LinkCycleError{}, err)
+	_, err = util.ResolveLinks("/nest/file", links)
+	assert.IsType(t, util.LinkCycleError{}, err)
+
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Storage: types2_4.Storage{
+			Links: []types2_4.Link{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/opt/bin",
+					},
+					LinkEmbedded1: types2_4.LinkEmbedded1{
+						Target: "/usr/local/bin",
+					},
+				},
+			},
+			Files: []types2_4.File{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/opt/bin/tool",
+					},
+					FileEmbedded1: types2_4.FileEmbedded1{
+						Contents: types2_4.FileContents{
+							Source: "data:,",
+						},
+					},
+				},
+			},
+			Directories: []types2_4.Directory{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/opt/binary",
+					},
+				},
+			},
+		},
+	}
+
+	_, err = v24tov31.Translate(cfg, nil)
+	assert.IsType(t, util.UsesOwnLinkError{}, err)
+
+	res, report, err := v24tov31.ResolveLinks(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed resolving links: %v", err)
+	}
+	assert.Equal(t, "/usr/local/bin/tool", res.Storage.Files[0].Path)
+	assert.Equal(t, "/opt/binary", res.Storage.Directories[0].Path)
+	assert.Equal(t, []util.Rewrite{
+		{Path: "$.storage.files[0].path", Message: "/opt/bin/tool resolved through links to /usr/local/bin/tool"},
+	}, report)
+	// the input is not modified
+	assert.Equal(t, "/opt/bin/tool", cfg.Storage.Files[0].Path)
+
+	out, tr, err := translate.TranslateConfig(cfg, types3_1.MaxVersion, translate.Options{ResolveLinks: true})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, report, tr.Rewrites)
+	assert.Equal(t, "/usr/local/bin/tool", out.(types3_1.Config).Storage.Files[0].Path)
+
+	cfg.Storage.Links = append(cfg.Storage.Links, types2_4.Link{
+		Node: types2_4.Node{
+			Filesystem: "root",
+			Path:       "/usr/local/bin",
+		},
+		LinkEmbedded1: types2_4.LinkEmbedded1{
+			Target: "/opt/bin",
+		},
+	})
+	_, _, err = v24tov31.ResolveLinks(cfg, nil)
+	assert.IsType(t, util.LinkCycleError{}, err)
+}
+
+func TestReportAllProblems(t *testing.T) {
+	// every unsupported field is reported, not only the first
+	_, err := v32tov31.Translate(types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Passwd: types3_2.Passwd{
+			Users: []types3_2.PasswdUser{
+				{
+					Name:        "core",
+					ShouldExist: util.BoolPStrict(false),
+				},
+			},
+		},
+		Storage: types3_2.Storage{
+			Luks: []types3_2.Luks{
+				{
+					Name:   "z",
+					Device: util.StrP("/dev/z"),
+				},
+			},
+		},
+	})
+	assert.EqualError(t, err, "$.passwd.users[0].shouldExist: shouldExist is not supported on 3.1\n$.storage.luks: luks is not supported on 3.1")
+
+	// the Check functions report every problem too
+	cfg := types2_4.Config{
+		Ignition: types2_4.Ignition{
+			Version: "2.4.0",
+		},
+		Storage: types2_4.Storage{
+			Filesystems: []types2_4.Filesystem{
+				{
+					Name: "var",
+					Mount: &types2_4.Mount{
+						Device: "/dev/sdb",
+						Format: "xfs",
+					},
+				},
+			},
+			Directories: []types2_4.Directory{
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/etc/foo",
+					},
+				},
+				{
+					Node: types2_4.Node{
+						Filesystem: "root",
+						Path:       "/etc/foo",
+					},
+				},
+			},
+		},
+		Systemd: types2_4.Systemd{
+			Units: []types2_4.Unit{
+				{
+					Name: "foo.service",
+				},
+				{
+					Name: "foo.service",
+				},
+			},
+		},
+	}
+	err = v24tov31.Check2_4(cfg, nil)
+	assert.Equal(t, util.Errors{
+		util.NoFilesystemError{Path: "$.storage.filesystems[0].name", Name: "var"},
+		util.DuplicateInodeError{Path: "$.storage.directories[1].path", Old: "Directory: /etc/foo", New: "Directory: /etc/foo"},
+		util.DuplicateUnitError{Path: "$.systemd.units[1].name", Name: "foo.service"},
+	}, err)
+	var dup util.DuplicateUnitError
+	assert.ErrorAs(t, err, &dup)
+	assert.Equal(t, "foo.service", dup.Name)
+
+	// a single problem is returned as is
+	cfg.Storage.Directories = nil
+	err = v24tov31.Check2_4(cfg, map[string]string{"var": "/var"})
+	assert.Equal(t, util.DuplicateUnitError{Path: "$.systemd.units[1].name", Name: "foo.service"}, err)
+}
+
+func TestUnsupportedFeatureError(t *testing.T) {
+	_, err := v31tov22.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+		Storage: types3_1.Storage{
+			Files: []types3_1.File{
+				{
+					Node: types3_1.Node{
+						Path: "/etc/motd",
+					},
+				},
+				{
+					Node: types3_1.Node{
+						Path: "/etc/issue",
+					},
+					FileEmbedded1: types3_1.FileEmbedded1{
+						Append: []types3_1.Resource{
+							{
+								Source: util.StrP("https://example.com/issue"),
+							},
+							{
+								Source: util.StrP("https://example.com/issue"),
+								HTTPHeaders: types3_1.HTTPHeaders{
+									{
+										Name:  "Authorization",
+										Value: util.StrP("Basic"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	var unsupported util.UnsupportedFeatureError
+	if assert.ErrorAs(t, err, &unsupported) {
+		assert.Equal(t, "$.storage.files[1].append[1].httpHeaders", unsupported.Path)
+		assert.Equal(t, util.FeatureHTTPHeaders, unsupported.Feature)
+		assert.Equal(t, "2.2", unsupported.Version)
+	}
+
+	// nested fields found by walking the config carry their path too
+	_, err = v34tov33.Translate(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Luks: []types3_4.Luks{
+				{
+					Name:        "z",
+					Device:      util.StrP("/dev/z"),
+					Discard:     util.BoolP(true),
+					OpenOptions: []types3_4.OpenOption{"--perf-no_read_workqueue"},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.Errors{
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks[0].discard",
+			Feature: util.FeatureLuksDiscard,
+			Version: "3.3",
+		},
+		util.UnsupportedFeatureError{
+			Path:    "$.storage.luks[0].openOptions",
+			Feature: util.FeatureLuksOpenOptions,
+			Version: "3.3",
+		},
+	}, err)
+}
+
+func TestCheckErrors(t *testing.T) {
+	node := func(fs, p string) types2_4.Node {
+		return types2_4.Node{
+			Filesystem: fs,
+			Path:       p,
+		}
+	}
+	base := func() types2_4.Config {
+		return types2_4.Config{
+			Ignition: types2_4.Ignition{
+				Version: "2.4.0",
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		mutate func(*types2_4.Config)
+		target interface{}
+		path   string
+	}{
+		{
+			"networkd",
+			func(c *types2_4.Config) {
+				c.Networkd.Units = []types2_4.Networkdunit{{Name: "eth0.network", Contents: "[Match]\nName=eth0"}}
+			},
+			&util.NetworkdError{},
+			"$.networkd",
+		},
+		{
+			"no filesystem",
+			func(c *types2_4.Config) {
+				c.Storage.Filesystems = []types2_4.Filesystem{{Name: "var", Mount: &types2_4.Mount{Device: "/dev/sdb", Format: "xfs"}}}
+			},
+			&util.NoFilesystemError{},
+			"$.storage.filesystems[0].name",
+		},
+		{
+			"duplicate file",
+			func(c *types2_4.Config) {
+				c.Storage.Files = []types2_4.File{{Node: node("root", "/a")}, {Node: node("root", "/a")}}
+			},
+			&util.DuplicateInodeError{},
+			"$.storage.files[1].path",
+		},
+		{
+			"duplicate directory",
+			func(c *types2_4.Config) {
+				c.Storage.Files = []types2_4.File{{Node: node("root", "/a")}}
+				c.Storage.Directories = []types2_4.Directory{{Node: node("root", "/a")}}
+			},
+			&util.DuplicateInodeError{},
+			"$.storage.directories[0].path",
+		},
+		{
+			"duplicate link",
+			func(c *types2_4.Config) {
+				c.Storage.Directories = []types2_4.Directory{{Node: node("root", "/a")}}
+				c.Storage.Links = []types2_4.Link{{Node: node("root", "/a"), LinkEmbedded1: types2_4.LinkEmbedded1{Target: "/b"}}}
+			},
+			&util.DuplicateInodeError{},
+			"$.storage.links[0].path",
+		},
+		{
+			"file through own link",
+			func(c *types2_4.Config) {
+				c.Storage.Files = []types2_4.File{{Node: node("root", "/a/f")}}
+				c.Storage.Links = []types2_4.Link{{Node: node("root", "/a"), LinkEmbedded1: types2_4.LinkEmbedded1{Target: "/b"}}}
+			},
+			&util.UsesOwnLinkError{},
+			"$.storage.files[0].path",
+		},
+		{
+			"directory through own link",
+			func(c *types2_4.Config) {
+				c.Storage.Directories = []types2_4.Directory{{Node: node("root", "/a/d")}}
+				c.Storage.Links = []types2_4.Link{{Node: node("root", "/a"), LinkEmbedded1: types2_4.LinkEmbedded1{Target: "/b"}}}
+			},
+			&util.UsesOwnLinkError{},
+			"$.storage.directories[0].path",
+		},
+		{
+			"link through own link",
+			func(c *types2_4.Config) {
+				c.Storage.Links = []types2_4.Link{
+					{Node: node("root", "/a"), LinkEmbedded1: types2_4.LinkEmbedded1{Target: "/b"}},
+					{Node: node("root", "/a/l"), LinkEmbedded1: types2_4.LinkEmbedded1{Target: "/c"}},
+				}
+			},
+			&util.UsesOwnLinkError{},
+			"$.storage.links[1].path",
+		},
+		{
+			"duplicate unit",
+			func(c *types2_4.Config) {
+				c.Systemd.Units = []types2_4.Unit{{Name: "a.service"}, {Name: "a.service"}}
+			},
+			&util.DuplicateUnitError{},
+			"$.systemd.units[1].name",
+		},
+		{
+			"duplicate dropin",
+			func(c *types2_4.Config) {
+				c.Systemd.Units = []types2_4.Unit{{Name: "a.service", Dropins: []types2_4.SystemdDropin{{Name: "a.conf"}, {Name: "a.conf"}}}}
+			},
+			&util.DuplicateDropinError{},
+			"$.systemd.units[0].dropins[1].name",
+		},
+	}
+	for _, test := range tests {
+		cfg := base()
+		test.mutate(&cfg)
+		err := v24tov31.Check2_4(cfg, nil)
+		if !assert.ErrorAs(t, err, test.target, test.name) {
+			continue
+		}
+		var configErr util.ConfigError
+		if assert.ErrorAs(t, err, &configErr, test.name) {
+			assert.Equal(t, test.path, configErr.ConfigPath(), test.name)
+			assert.ErrorIs(t, err, configErr.Category(), test.name)
+		}
+	}
+
+	// categories tell the problems in a report apart
+	cfg := base()
+	cfg.Systemd.Units = []types2_4.Unit{{Name: "a.service"}, {Name: "a.service"}}
+	err := v24tov31.Check2_4(cfg, nil)
+	assert.True(t, errors.Is(err, util.ErrDuplicateUnit))
+	assert.False(t, errors.Is(err, util.ErrDuplicateInode))
+	assert.ErrorIs(t, util.UsesNetworkdError, util.ErrUsesNetworkd)
+
+	// and match inside a list of problems
+	cfg.Storage.Directories = []types2_4.Directory{
+		{Node: types2_4.Node{Filesystem: "root", Path: "/a"}},
+		{Node: types2_4.Node{Filesystem: "root", Path: "/a"}},
+	}
+	err = v24tov31.Check2_4(cfg, nil)
+	assert.IsType(t, util.Errors{}, err)
+	assert.True(t, errors.Is(err, util.ErrDuplicateUnit))
+	assert.True(t, errors.Is(err, util.ErrDuplicateInode))
+	assert.False(t, errors.Is(err, util.ErrLinkCycle))
+	var inodeErr util.DuplicateInodeError
+	if assert.ErrorAs(t, err, &inodeErr) {
+		assert.Equal(t, "$.storage.directories[1].path", inodeErr.ConfigPath())
+	}
+}
+
+func TestTranslateLossy(t *testing.T) {
+	in := types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+			Config: types3_4.IgnitionConfig{
+				Merge: []types3_4.Resource{
+					{
+						Source: util.StrP("arn:aws:s3:us-west-1:123456789012:accesspoint/test/object/some/path"),
+					},
+					{
+						Source: util.StrP("https://example.com/config.ign"),
+					},
+				},
+			},
+		},
+		Storage: types3_4.Storage{
+			Files: []types3_4.File{
+				{
+					Node: types3_4.Node{
+						Path: "/usr/local/bin/tool",
+					},
+					FileEmbedded1: types3_4.FileEmbedded1{
+						Mode: util.IntP(04755),
+					},
+				},
+			},
+			Luks: []types3_4.Luks{
+				{
+					Name:    "z",
+					Device:  util.StrP("/dev/z"),
+					Discard: util.BoolP(true),
+				},
+			},
+		},
+	}
+	res, degraded, err := v34tov33.TranslateLossy(in)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.ignition.config.merge[0]", Feature: util.FeatureArnSource, Action: util.ActionDrop, Version: "3.3"},
+		{Path: "$.storage.files[0].mode", Feature: util.FeatureSpecialModeBits, Action: util.ActionDrop, Version: "3.3"},
+		{Path: "$.storage.luks[0].discard", Feature: util.FeatureLuksDiscard, Action: util.ActionDrop, Version: "3.3"},
+	}, degraded)
+	assert.Equal(t, []types3_3.Resource{{Source: util.StrP("https://example.com/config.ign")}}, res.Ignition.Config.Merge)
+	assert.Nil(t, res.Storage.Files[0].Mode)
+	assert.Len(t, res.Storage.Luks, 1)
+
+	// the whole resource is dropped, and reported as such
+	res, degraded, err = v34tov33.TranslateLossy(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Files: []types3_4.File{
+				{
+					Node: types3_4.Node{
+						Path: "/etc/motd",
+					},
+					FileEmbedded1: types3_4.FileEmbedded1{
+						Contents: types3_4.Resource{
+							Source:      util.StrP("arn:aws:s3:us-west-1:123456789012:accesspoint/test/object/motd"),
+							Compression: util.StrP("gzip"),
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.storage.files[0].contents", Feature: util.FeatureArnSource, Action: util.ActionDrop, Version: "3.3"},
+	}, degraded)
+	assert.Equal(t, types3_3.Resource{}, res.Storage.Files[0].Contents)
+
+	// the caller's config is left alone
+	assert.Len(t, in.Ignition.Config.Merge, 2)
+	assert.Equal(t, util.IntP(04755), in.Storage.Files[0].Mode)
+	assert.Equal(t, util.BoolP(true), in.Storage.Luks[0].Discard)
+
+	// the strict translation still fails
+	_, err = v34tov33.Translate(in)
+	assert.ErrorIs(t, err, util.ErrUnsupportedFeature)
+
+	// users that should not exist are dropped entirely rather than created
+	res2_4, _, degraded, err := v32tov24.TranslateLossy(types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Passwd: types3_2.Passwd{
+			Users: []types3_2.PasswdUser{
+				{
+					Name:        "core",
+					ShouldExist: util.BoolPStrict(false),
+				},
+				{
+					Name: "user",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.passwd.users[0].shouldExist", Feature: util.FeatureShouldExist, Action: util.ActionDrop, Version: "2.4"},
+	}, degraded)
+	assert.Equal(t, []types2_4.PasswdUser{{Name: "user"}}, res2_4.Passwd.Users)
+
+	// chained translations report the target version
+	_, tr, err := translate.TranslateConfig(in, types2_4.MaxVersion, translate.Options{DropUnsupported: true})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Len(t, tr.Degraded, 4)
+	for _, d := range tr.Degraded {
+		assert.Equal(t, "2.4", d.Version)
+	}
+	_, _, err = translate.TranslateConfig(in, types2_4.MaxVersion, translate.Options{})
+	assert.ErrorIs(t, err, util.ErrUnsupportedFeature)
+}
+
+func TestTranslatePolicy(t *testing.T) {
+	// Ignition rejects headers on data: URLs already, so the workaround
+	// cannot remove those of a valid config
+	_, _, _, err := v31tov22.TranslateWithPolicy(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+		Storage: types3_1.Storage{
+			Files: []types3_1.File{
+				{
+					Node: types3_1.Node{
+						Path: "/etc/issue",
+					},
+					FileEmbedded1: types3_1.FileEmbedded1{
+						Contents: types3_1.Resource{
+							Source: util.StrP("https://example.com/issue"),
+							HTTPHeaders: types3_1.HTTPHeaders{
+								{
+									Name:  "Authorization",
+									Value: util.StrP("Basic"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}, 0, util.Policy{util.FeatureHTTPHeaders: util.ActionWorkaround})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.files[0].contents.httpHeaders",
+		Feature: util.FeatureHTTPHeaders,
+		Version: "2.2",
+	}, err)
+
+	check := util.FeatureCheck{
+		Version: "2.2",
+		Policy:  util.Policy{util.FeatureHTTPHeaders: util.ActionWorkaround},
+	}
+	check.Workaround("$.ignition.config.replace.httpHeaders", util.FeatureHTTPHeaders, func() {
+		t.Error("dropped instead of working around")
+	}, func() bool {
+		return util.IsDataURL(util.StrP("data:,hello"))
+	})
+	assert.NoError(t, check.Errors.ErrorOrNil())
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.ignition.config.replace.httpHeaders", Feature: util.FeatureHTTPHeaders, Action: util.ActionWorkaround, Version: "2.2"},
+	}, check.Degraded)
+
+	// the workaround for special mode bits masks them out
+	res, tr, err := translate.TranslateConfig(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		Storage: types3_4.Storage{
+			Files: []types3_4.File{
+				{
+					Node: types3_4.Node{
+						Path: "/usr/local/bin/tool",
+					},
+					FileEmbedded1: types3_4.FileEmbedded1{
+						Mode: util.IntP(04755),
+					},
+				},
+			},
+		},
+	}, types3_3.MaxVersion, translate.Options{
+		Policy: util.Policy{
+			util.FeatureSpecialModeBits: util.ActionWorkaround,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.storage.files[0].mode", Feature: util.FeatureSpecialModeBits, Action: util.ActionWorkaround, Version: "3.3"},
+	}, tr.Degraded)
+	assert.Equal(t, util.IntP(0755), res.(types3_3.Config).Storage.Files[0].Mode)
+
+	// 2.2 cannot wipe partition entries, which are dropped unless the
+	// policy says to fail
+	_, _, degraded, err := v32tov22.TranslateWithPolicy(downtranslateConfig3_2, 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.storage.disks[0].partitions[0].wipePartitionEntry", Feature: util.FeatureWipePartitionEntry, Action: util.ActionDrop, Version: "2.2"},
+	}, degraded)
+	assert.False(t, util.Policy{}.IsStrict())
+	strict := util.Policy{util.FeatureWipePartitionEntry: util.ActionFail}
+	assert.True(t, strict.IsStrict())
+	for _, tr := range []func() error{
+		func() error {
+			_, _, _, err := v30tov22.TranslateWithPolicy(downtranslateConfig3_0, 0, strict)
+			return err
+		},
+		func() error {
+			_, _, _, err := v31tov22.TranslateWithPolicy(downtranslateConfig3_1, 0, strict)
+			return err
+		},
+		func() error {
+			_, _, _, err := v32tov22.TranslateWithPolicy(downtranslateConfig3_2, 0, strict)
+			return err
+		},
+	} {
+		assert.Equal(t, util.UnsupportedFeatureError{
+			Path:    "$.storage.disks[0].partitions[0].wipePartitionEntry",
+			Feature: util.FeatureWipePartitionEntry,
+			Version: "2.2",
+		}, tr())
+	}
+
+	// features missing from the policy fail
+	_, _, err = translate.TranslateConfig(types3_4.Config{
+		Ignition: types3_4.Ignition{
+			Version: "3.4.0",
+		},
+		KernelArguments: types3_4.KernelArguments{
+			ShouldExist: []types3_4.KernelArgument{"quiet"},
+		},
+	}, types2_4.MaxVersion, translate.Options{
+		Policy: util.Policy{
+			util.FeatureSpecialModeBits: util.ActionWorkaround,
+		},
+	})
+	var unsupported util.UnsupportedFeatureError
+	if assert.ErrorAs(t, err, &unsupported) {
+		assert.Equal(t, util.FeatureKernelArguments, unsupported.Feature)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	p, err := util.ParsePolicy([]byte("specialModeBits: workaround\nkernelArguments: drop\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, util.Policy{
+		util.FeatureSpecialModeBits: util.ActionWorkaround,
+		util.FeatureKernelArguments: util.ActionDrop,
+	}, p)
+	assert.Equal(t, util.ActionFail, p.Action(util.FeatureCex))
+
+	p, err = util.ParsePolicy([]byte(`{"httpHeaders": "workaround", "cex": "fail"}`))
+	assert.NoError(t, err)
+	assert.Equal(t, util.Policy{
+		util.FeatureHTTPHeaders: util.ActionWorkaround,
+		util.FeatureCex:         util.ActionFail,
+	}, p)
+
+	for _, bad := range []string{
+		"unknown: drop",
+		"cex: ignore",
+		"cex: workaround",
+	} {
+		_, err := util.ParsePolicy([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestNestedUnsupportedFeatures(t *testing.T) {
+	in := types3_5.Config{
+		Ignition: types3_5.Ignition{
+			Version: "3.5.0",
+		},
+		Storage: types3_5.Storage{
+			Luks: []types3_5.Luks{
+				{
+					Name:   "a",
+					Device: util.StrP("/dev/a"),
+				},
+				{
+					Name:   "b",
+					Device: util.StrP("/dev/b"),
+					Cex: types3_5.Cex{
+						Enabled: util.BoolP(true),
+					},
+				},
+			},
+		},
+	}
+	_, err := v35tov34.Translate(in)
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.luks[1].cex",
+		Feature: util.FeatureCex,
+		Version: "3.4",
+	}, err)
+
+	res, degraded, err := v35tov34.TranslateLossy(in)
+	if err != nil {
+		t.Fatalf("Failed translation: %v", err)
+	}
+	assert.Equal(t, []util.Degradation{
+		{Path: "$.storage.luks[1].cex", Feature: util.FeatureCex, Action: util.ActionDrop, Version: "3.4"},
+	}, degraded)
+	assert.Len(t, res.Storage.Luks, 2)
+	assert.Equal(t, util.BoolP(true), in.Storage.Luks[1].Cex.Enabled)
+
+	// a disabled cex is the same as none
+	in.Storage.Luks[1].Cex.Enabled = util.BoolPStrict(false)
+	res, err = v35tov34.Translate(in)
+	assert.NoError(t, err)
+	assert.Len(t, res.Storage.Luks, 2)
+	_, err = v35tov24.Translate(in)
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.luks",
+		Feature: util.FeatureLuks,
+		Version: "2.4",
+	}, err)
+
+	// the walker reports nested fields of the other translators as well
+	_, err = v31tov30.Translate(types3_1.Config{
+		Ignition: types3_1.Ignition{
+			Version: "3.1.0",
+		},
+		Storage: types3_1.Storage{
+			Files: []types3_1.File{
+				{
+					Node: types3_1.Node{
+						Path: "/etc/motd",
+					},
+					FileEmbedded1: types3_1.FileEmbedded1{
+						Contents: types3_1.Resource{
+							Source:      util.StrP("https://example.com/motd.gz"),
+							Compression: util.StrP("gzip"),
+							Verification: types3_1.Verification{
+								Hash: util.StrP("sha256-e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"),
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	assert.Equal(t, util.UnsupportedFeatureError{
+		Path:    "$.storage.files[0].contents.verification.hash",
+		Feature: util.FeatureSha256,
+		Version: "3.0",
+	}, err)
+}
+
+// fieldPaths returns the JSON path of every scalar field of t, with [*] for
+// slice indices
+func fieldPaths(t reflect.Type, path string, ret map[string]bool) map[string]bool {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return fieldPaths(t.Elem(), path, ret)
+	case reflect.Slice:
+		return fieldPaths(t.Elem(), path+"[*]", ret)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			p := path
+			if !f.Anonymous {
+				p += "." + strings.Split(f.Tag.Get("json"), ",")[0]
+			}
+			fieldPaths(f.Type, p, ret)
+		}
+		return ret
+	}
+	ret[path] = true
+	return ret
+}
+
+// setPaths returns the JSON path of every scalar field set in the configs,
+// with [*] for slice indices
+func setPaths(t *testing.T, cfgs ...interface{}) map[string]bool {
+	ret := map[string]bool{}
+	for _, cfg := range cfgs {
+		b, err := json.Marshal(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var doc interface{}
+		if err := json.Unmarshal(b, &doc); err != nil {
+			t.Fatal(err)
+		}
+		collectPaths(doc, "$", ret)
+	}
+	return ret
+}
+
+func collectPaths(v interface{}, path string, ret map[string]bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			collectPaths(child, path+"."+k, ret)
+		}
+	case []interface{}:
+		for _, child := range v {
+			collectPaths(child, path+"[*]", ret)
+		}
+	case string:
+		ret[path] = ret[path] || v != ""
+	case float64:
+		ret[path] = ret[path] || v != 0
+	case bool:
+		ret[path] = ret[path] || v
+	}
+}
+
+// coverageValues holds a valid value for every scalar field of the spec 3
+// configs, keyed by a suffix of its JSON path; the longest matching suffix
+// wins. TestFieldCoverage fails for fields missing here, so new fields get
+// covered as well.
+var coverageValues = map[string]interface{}{
+	".ignition.version":                  "3.0.0",
+	".timeouts.httpResponseHeaders":      5,
+	".timeouts.httpTotal":                10,
+	".proxy.httpProxy":                   "http://proxy.example.com",
+	".proxy.httpsProxy":                  "https://proxy.example.com",
+	".proxy.noProxy[*]":                  "example.org",
+	".source":                            "https://example.com/source",
+	".compression":                       "gzip",
+	".httpHeaders[*].name":               "X-Auth",
+	".httpHeaders[*].value":              "secret",
+	".verification.hash":                 aSha512Hash,
+	".kernelArguments.shouldExist[*]":    "quiet",
+	".kernelArguments.shouldNotExist[*]": "splash",
+	".passwd.users[*].name":              "core",
+	".passwd.users[*].uid":               1000,
+	".passwd.groups[*].name":             "admins",
+	".passwd.groups[*].gid":              1000,
+	".passwordHash":                      "$6$salt$hash",
+	".sshAuthorizedKeys[*]":              "ssh-ed25519 AAAA",
+	".gecos":                             "CoreOS Admin",
+	".homeDir":                           "/home/core",
+	".primaryGroup":                      "admins",
+	".groups[*]":                         "wheel",
+	".shell":                             "/bin/bash",
+	".storage.directories[*].path":       "/opt/dir",
+	".storage.files[*].path":             "/opt/file",
+	".storage.links[*].path":             "/opt/link",
+	".target":                            "/opt/file",
+	".mode":                              0644,
+	".user.id":                           1000,
+	".user.name":                         "core",
+	".group.id":                          1000,
+	".group.name":                        "admins",
+	".storage.disks[*].device":           "/dev/sda",
+	".partitions[*].label":               "data",
+	".partitions[*].number":              1,
+	".sizeMiB":                           1024,
+	".startMiB":                          2048,
+	".typeGuid":                          aUUID,
+	".guid":                              aUUID,
+	".storage.raid[*].name":              "md0",
+	".level":                             "raid1",
+	".devices[*]":                        "/dev/sdb",
+	".spares":                            1,
+	".storage.filesystems[*].device":     "/dev/md/md0",
+	".format":                            "ext4",
+	".storage.filesystems[*].label":      "fs",
+	".storage.filesystems[*].path":       "/var",
+	".mountOptions[*]":                   "ro",
+	".uuid":                              aUUID,
+	".options[*]":                        "--verbose",
+	".storage.luks[*].name":              "luks",
+	".storage.luks[*].label":             "luks",
+	".storage.luks[*].device":            "/dev/sdc",
+	".openOptions[*]":                    "--allow-discards",
+	".tang[*].url":                       "https://tang.example.com",
+	".tang[*].thumbprint":                "thumbprint",
+	".tang[*].advertisement":             `{"payload": "adv"}`,
+	".threshold":                         1,
+	".custom.pin":                        "tpm2",
+	".custom.config":                     "{}",
+	".systemd.units[*].name":             "example.service",
+	".systemd.units[*].contents":         "[Service]\nType=oneshot",
+	".dropins[*].name":                   "override.conf",
+	".dropins[*].contents":               "[Service]\nTimeoutSec=0",
+	".overwrite":                         true,
+	".hard":                              true,
+	".wipeTable":                         true,
+	".wipePartitionEntry":                true,
+	".shouldExist":                       true,
+	".resize":                            true,
+	".wipeFilesystem":                    true,
+	".wipeVolume":                        true,
+	".discard":                           true,
+	".tpm2":                              true,
+	".needsNetwork":                      true,
+	".noCreateHome":                      true,
+	".noUserGroup":                       true,
+	".noLogInit":                         true,
+	".system":                            true,
+	".enabled":                           true,
+	".mask":                              true,
+}
+
+// coverageVariants lists the fields to leave out of each of the configs
+// TestFieldCoverage fills in, as some fields rule out others
+var coverageVariants = [][]string{
+	{".clevis.custom", ".cex", ".user.name", ".group.name"},
+	{".clevis.tang", ".clevis.tpm2", ".clevis.threshold", ".cex", ".user.id", ".group.id"},
+	// cex rules out clevis and key files, and files without contents
+	// cannot be overwritten
+	{".clevis", ".keyFile", ".user.name", ".group.name", ".storage.files[*].contents", ".storage.files[*].append", ".storage.files[*].overwrite"},
+}
+
+// fillConfig sets every field of v, and one element of every slice, from
+// coverageValues, except for the fields at paths ending in one of skip
+func fillConfig(t *testing.T, v reflect.Value, path string, skip []string) {
+	for _, s := range skip {
+		if strings.HasSuffix(path, s) {
+			return
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fillConfig(t, v.Elem(), path, skip)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 1, 1))
+		fillConfig(t, v.Index(0), path+"[*]", skip)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			f := v.Type().Field(i)
+			p := path
+			if !f.Anonymous {
+				p += "." + strings.Split(f.Tag.Get("json"), ",")[0]
+			}
+			fillConfig(t, v.Field(i), p, skip)
+		}
+	default:
+		key := ""
+		for k := range coverageValues {
+			if strings.HasSuffix(path, k) && len(k) > len(key) {
+				key = k
+			}
+		}
+		if key == "" {
+			t.Errorf("no value to set %s to", path)
+			return
+		}
+		v.Set(reflect.ValueOf(coverageValues[key]).Convert(v.Type()))
+	}
+}
+
+// TestFieldCoverage translates configs setting every field of their spec
+// version to older versions, checking that the translators carry each field
+// over or reject it, and report dropping it when asked to.
+func TestFieldCoverage(t *testing.T) {
+	util.DebugCoverage = true
+	defer func() { util.DebugCoverage = false }()
+
+	tests := []struct {
+		name      string
+		config    interface{}
+		version   string
+		translate func(cfg interface{}, policy util.Policy) error
+	}{
+		{"3.0 -> 2.2", types3_0.Config{}, "3.0.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v30tov22.TranslateWithPolicy(cfg.(types3_0.Config), 0, policy)
+			return err
+		}},
+		{"3.1 -> 2.2", types3_1.Config{}, "3.1.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v31tov22.TranslateWithPolicy(cfg.(types3_1.Config), 0, policy)
+			return err
+		}},
+		{"3.1 -> 2.4", types3_1.Config{}, "3.1.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v31tov24.TranslateWithPolicy(cfg.(types3_1.Config), policy)
+			return err
+		}},
+		{"3.1 -> 3.0", types3_1.Config{}, "3.1.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, err := v31tov30.TranslateWithPolicy(cfg.(types3_1.Config), policy)
+			return err
+		}},
+		{"3.2 -> 2.2", types3_2.Config{}, "3.2.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v32tov22.TranslateWithPolicy(cfg.(types3_2.Config), 0, policy)
+			return err
+		}},
+		{"3.2 -> 2.4", types3_2.Config{}, "3.2.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v32tov24.TranslateWithPolicy(cfg.(types3_2.Config), policy)
+			return err
+		}},
+		{"3.2 -> 3.1", types3_2.Config{}, "3.2.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, err := v32tov31.TranslateWithPolicy(cfg.(types3_2.Config), policy)
+			return err
+		}},
+		{"3.3 -> 2.4", types3_3.Config{}, "3.3.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v33tov24.TranslateWithPolicy(cfg.(types3_3.Config), policy)
+			return err
+		}},
+		{"3.3 -> 3.2", types3_3.Config{}, "3.3.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, err := v33tov32.TranslateWithPolicy(cfg.(types3_3.Config), policy)
+			return err
+		}},
+		{"3.4 -> 2.4", types3_4.Config{}, "3.4.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v34tov24.TranslateWithPolicy(cfg.(types3_4.Config), policy)
+			return err
+		}},
+		{"3.4 -> 3.3", types3_4.Config{}, "3.4.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, err := v34tov33.TranslateWithPolicy(cfg.(types3_4.Config), policy)
+			return err
+		}},
+		{"3.5 -> 2.4", types3_5.Config{}, "3.5.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, _, err := v35tov24.TranslateWithPolicy(cfg.(types3_5.Config), policy)
+			return err
+		}},
+		{"3.5 -> 3.4", types3_5.Config{}, "3.5.0", func(cfg interface{}, policy util.Policy) error {
+			_, _, err := v35tov34.TranslateWithPolicy(cfg.(types3_5.Config), policy)
+			return err
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var cfgs []interface{}
+			for i, skip := range coverageVariants {
+				v := reflect.New(reflect.TypeOf(test.config)).Elem()
+				fillConfig(t, v, "$", skip)
+				v.FieldByName("Ignition").FieldByName("Version").SetString(test.version)
+				cfg := v.Interface()
+				cfgs = append(cfgs, cfg)
+
+				// strict translation may only fail on the config
+				err := test.translate(cfg, nil)
+				var errs util.Errors
+				if !errors.As(err, &errs) && err != nil {
+					errs = util.Errors{err}
+				}
+				for _, err := range errs {
+					var configErr util.ConfigError
+					assert.ErrorAs(t, err, &configErr, "variant %d", i)
+				}
+
+				err = test.translate(cfg, util.DropPolicy())
+				errs = nil
+				if !errors.As(err, &errs) && err != nil {
+					errs = util.Errors{err}
+				}
+				for _, err := range errs {
+					t.Errorf("variant %d: %v", i, err)
+				}
+			}
+
+			set := setPaths(t, cfgs...)
+			for p := range fieldPaths(reflect.TypeOf(test.config), "$", map[string]bool{}) {
+				assert.True(t, set[p], "%s not covered", p)
+			}
+		})
+	}
+}
+
+func TestTranslateEmptyFiles(t *testing.T) {
+	empty := types3_2.Config{
+		Ignition: types3_2.Ignition{
+			Version: "3.2.0",
+		},
+		Storage: types3_2.Storage{
+			Files: []types3_2.File{
+				{
+					Node: types3_2.Node{
+						Path: "/etc/ignition-firstboot-done",
+						User: types3_2.NodeUser{
+							ID: util.IntPStrict(0),
+						},
+					},
+					FileEmbedded1: types3_2.FileEmbedded1{
+						Mode: util.IntP(0600),
+					},
+				},
+			},
+		},
+	}
+	res, err := v32tov24.Translate(empty)
+	assert.NoError(t, err)
+	assert.Equal(t, []types2_4.File{
+		{
+			Node: types2_4.Node{
+				Filesystem: "root",
+				Path:       "/etc/ignition-firstboot-done",
+				Overwrite:  util.BoolPStrict(false),
+				User: &types2_4.NodeUser{
+					ID: util.IntPStrict(0),
+				},
+			},
+			FileEmbedded1: types2_4.FileEmbedded1{
+				Append: true,
+				Contents: types2_4.FileContents{
+					Source: "data:,",
+				},
+				Mode: util.IntP(0600),
+			},
+		},
+	}, res.Storage.Files)
+
+	res2_2, err := v32tov22.Translate(empty)
+	assert.NoError(t, err)
+	assert.Equal(t, []types2_2.File{
+		{
+			Node: types2_2.Node{
+				Filesystem: "root",
+				Path:       "/etc/ignition-firstboot-done",
+				Overwrite:  util.BoolPStrict(false),
+				User: &types2_2.NodeUser{
+					ID: util.IntPStrict(0),
+				},
+			},
+			FileEmbedded1: types2_2.FileEmbedded1{
+				Append: true,
+				Contents: types2_2.FileContents{
+					Source: "data:,",
+				},
+				Mode: util.IntP(0600),
+			},
+		},
+	}, res2_2.Storage.Files)
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// FieldMap maps the JSON paths of the fields of a source config, with [*]
+// for slice indices, to the paths a translator carries them to in the
+// target config. A mapping applies to the children of the field too, and
+// the longest matching path wins. Fields mapped to "" are consumed by the
+// translator without being carried, e.g. because they were checked to hold
+// their default. Fields missing from the map are expected at the same path.
+type FieldMap map[string]string
+
+// target returns where fields carries the field at path.
+func (fields FieldMap) target(path string) string {
+	best := ""
+	for from := range fields {
+		if (path == from || strings.HasPrefix(path, from+".") || strings.HasPrefix(path, from+"[")) && len(from) > len(best) {
+			best = from
+		}
+	}
+	if best == "" {
+		return path
+	}
+	to := fields[best]
+	if to == "" {
+		return ""
+	}
+	return to + strings.TrimPrefix(path, best)
+}
+
+// DebugCoverage makes the translators to older spec versions check every
+// translation with CheckCoverage, and fail it if a field was lost. It is
+// meant for debugging translators, as it marshals every config twice.
+var DebugCoverage = false
+
+// CheckTranslation runs CheckCoverage if DebugCoverage is set.
+func CheckTranslation(src, dst interface{}, fields FieldMap) error {
+	if !DebugCoverage {
+		return nil
+	}
+	return CheckCoverage(src, dst, fields)
+}
+
+// CheckCoverage checks that every field set in src is set in dst, the
+// translation of src, as well, after mapping its path with fields. Fields
+// carried to the same path are counted together. It returns a
+// LostFieldError for every field with fewer values in dst than in src, so
+// a translator cannot lose a field without rejecting or reporting it.
+func CheckCoverage(src, dst interface{}, fields FieldMap) error {
+	srcLeaves, err := leaves(src)
+	if err != nil {
+		return err
+	}
+	dstLeaves, err := leaves(dst)
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(srcLeaves))
+	for p := range srcLeaves {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	carried := map[string]int{}
+	for _, p := range paths {
+		if to := fields.target(p); to != "" {
+			carried[to] += srcLeaves[p]
+		}
+	}
+	var errs Errors
+	for _, p := range paths {
+		to := fields.target(p)
+		if to == "" {
+			continue
+		}
+		if n := carried[to] - dstLeaves[to]; n > 0 {
+			errs.Add(LostFieldError{Path: p, Count: n})
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// leaves returns how many values are set at each path of v when marshaled
+// to JSON. Paths use [*] for slice indices, and false, 0 and "" count as
+// unset.
+func leaves(v interface{}) (map[string]int, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, err
+	}
+	ret := map[string]int{}
+	countLeaves(doc, "$", ret)
+	return ret, nil
+}
+
+func countLeaves(v interface{}, path string, ret map[string]int) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			countLeaves(child, path+"."+k, ret)
+		}
+	case []interface{}:
+		for _, child := range v {
+			countLeaves(child, path+"[*]", ret)
+		}
+	case string:
+		if v != "" {
+			ret[path]++
+		}
+	case float64:
+		if v != 0 {
+			ret[path]++
+		}
+	case bool:
+		if v {
+			ret[path]++
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Error definitions
+//
+// Every error describing a problem with a config is a value type
+// implementing ConfigError, so errors.As works with the type itself (never
+// a pointer to it), and matches its Category with errors.Is.
+
+// ConfigError is implemented by the errors describing a problem with a
+// config
+type ConfigError interface {
+	error
+	// ConfigPath returns the JSON path of the offending entry, e.g.
+	// $.storage.files[3].path
+	ConfigPath() string
+	Category() Category
+}
+
+// Category is the kind of problem a ConfigError describes. The categories
+// are sentinel errors: errors.Is(err, ErrDuplicateInode) reports whether err
+// is or contains a DuplicateInodeError, including in an Errors list.
+type Category string
+
+func (c Category) Error() string {
+	return string(c)
+}
+
+const (
+	ErrUsesNetworkd       Category = "uses networkd"
+	ErrNoFilesystem       Category = "no filesystem mapping"
+	ErrNoMountpoint       Category = "no mountpoint"
+	ErrCreateRoot         Category = "create root filesystem"
+	ErrCreateConflict     Category = "create conflict"
+	ErrDuplicateInode     Category = "duplicate inode"
+	ErrUsesOwnLink        Category = "uses own link"
+	ErrLinkCycle          Category = "link cycle"
+	ErrDuplicateUnit      Category = "duplicate unit"
+	ErrDuplicateDropin    Category = "duplicate dropin"
+	ErrNetworkdConflict   Category = "networkd conflict"
+	ErrUnsupportedFeature Category = "unsupported feature"
+	ErrLostField          Category = "lost field"
+)
+
+// NetworkdError is for configs including a networkd section
+type NetworkdError struct{}
+
+// UsesNetworkdError is the error for including networkd configs
+var UsesNetworkdError = NetworkdError{}
+
+func (e NetworkdError) Error() string {
+	return "config includes deprecated networkd section - use Files instead"
+}
+
+func (e NetworkdError) ConfigPath() string   { return "$.networkd" }
+func (e NetworkdError) Category() Category   { return ErrUsesNetworkd }
+func (e NetworkdError) Is(target error) bool { return target == e.Category() }
+
+// NoFilesystemError is for when a filesystem is referenced in a config but there's no mapping to where
+// it should be mounted (i.e. `path` in v3+ configs)
+type NoFilesystemError struct {
+	Path string
+	Name string // name of the filesystem (its device in v1 configs)
+}
+
+func (e NoFilesystemError) Error() string {
+	return fmt.Sprintf("Config defined filesystem %q but no mapping was defined."+
+		"Please specify a path to be used as the filesystem mountpoint.", e.Name)
+}
+
+func (e NoFilesystemError) ConfigPath() string   { return e.Path }
+func (e NoFilesystemError) Category() Category   { return ErrNoFilesystem }
+func (e NoFilesystemError) Is(target error) bool { return target == e.Category() }
+
+// NoMountpointError is for when a file, directory or link is on a filesystem that is mapped to no
+// mountpoint (an empty path), so it has nowhere to be written
+type NoMountpointError struct {
+	Path string
+	Name string // name of the filesystem
+}
+
+func (e NoMountpointError) Error() string {
+	return fmt.Sprintf("Config writes to filesystem %q, which is mapped to no mountpoint. "+
+		"Please specify a path to be used as the filesystem mountpoint.", e.Name)
+}
+
+func (e NoMountpointError) ConfigPath() string   { return e.Path }
+func (e NoMountpointError) Category() Category   { return ErrNoMountpoint }
+func (e NoMountpointError) Is(target error) bool { return target == e.Category() }
+
+// CreateRootError is for when a spec v1 config creates the filesystem mapped to the root
+// filesystem, which spec 3 has no entry for
+type CreateRootError struct {
+	Path   string
+	Device string
+}
+
+func (e CreateRootError) Error() string {
+	return fmt.Sprintf("Filesystem %q is mapped to the root filesystem, which cannot be created on 3.0", e.Device)
+}
+
+func (e CreateRootError) ConfigPath() string   { return e.Path }
+func (e CreateRootError) Category() Category   { return ErrCreateRoot }
+func (e CreateRootError) Is(target error) bool { return target == e.Category() }
+
+// CreateConflictError is for when the deprecated create section of a v2 user sets a field
+// that the user itself sets to a different value
+type CreateConflictError struct {
+	Path string // JSON path of the field in the create section
+}
+
+func (e CreateConflictError) Error() string {
+	return fmt.Sprintf("%s: the user sets this field to a different value", e.Path)
+}
+
+func (e CreateConflictError) ConfigPath() string   { return e.Path }
+func (e CreateConflictError) Category() Category   { return ErrCreateConflict }
+func (e CreateConflictError) Is(target error) bool { return target == e.Category() }
+
+// DuplicateInodeError is for when files, directories, or links both specify the same path
+type DuplicateInodeError struct {
+	Path string // JSON path of the second occurance
+	Old  string // first occurance of the path
+	New  string // second occurance of the path
+}
+
+func (e DuplicateInodeError) Error() string {
+	return fmt.Sprintf("Config has conflicting inodes: %q and %q.  All files, directories and links must specify a unique `path`.", e.Old, e.New)
+}
+
+func (e DuplicateInodeError) ConfigPath() string   { return e.Path }
+func (e DuplicateInodeError) Category() Category   { return ErrDuplicateInode }
+func (e DuplicateInodeError) Is(target error) bool { return target == e.Category() }
+
+// UsesOwnLinkError is for when files, directories, or links use symlinks defined in the config
+// in their own path. This is disallowed in v3+ configs.
+type UsesOwnLinkError struct {
+	Path     string
+	LinkPath string
+	Name     string
+}
+
+func (e UsesOwnLinkError) Error() string {
+	return fmt.Sprintf("%s uses link defined in config %q. Please use a link not defined in Storage:Links", e.Name, e.LinkPath)
+}
+
+func (e UsesOwnLinkError) ConfigPath() string   { return e.Path }
+func (e UsesOwnLinkError) Category() Category   { return ErrUsesOwnLink }
+func (e UsesOwnLinkError) Is(target error) bool { return target == e.Category() }
+
+// LinkCycleError is for when resolving a path through the links defined in the config never
+// reaches a path outside of them
+type LinkCycleError struct {
+	Path string
+	File string // path on the filesystem at which the loop was found
+}
+
+func (e LinkCycleError) Error() string {
+	return fmt.Sprintf("Resolving %q through the links defined in the config loops forever.", e.File)
+}
+
+func (e LinkCycleError) ConfigPath() string   { return e.Path }
+func (e LinkCycleError) Category() Category   { return ErrLinkCycle }
+func (e LinkCycleError) Is(target error) bool { return target == e.Category() }
+
+// DuplicateUnitError is for when a unit name is used twice
+type DuplicateUnitError struct {
+	Path string
+	Name string
+}
+
+func (e DuplicateUnitError) Error() string {
+	return fmt.Sprintf("Config has duplicate unit name %q.  All units must specify a unique `name`.", e.Name)
+}
+
+func (e DuplicateUnitError) ConfigPath() string   { return e.Path }
+func (e DuplicateUnitError) Category() Category   { return ErrDuplicateUnit }
+func (e DuplicateUnitError) Is(target error) bool { return target == e.Category() }
+
+// DuplicateDropinError is for when a unit has multiple dropins with the same name
+type DuplicateDropinError struct {
+	Path string
+	Unit string
+	Name string
+}
+
+func (e DuplicateDropinError) Error() string {
+	return fmt.Sprintf("Config has duplicate dropin name %q in unit %q.  All dropins must specify a unique `name`.", e.Name, e.Unit)
+}
+
+func (e DuplicateDropinError) ConfigPath() string   { return e.Path }
+func (e DuplicateDropinError) Category() Category   { return ErrDuplicateDropin }
+func (e DuplicateDropinError) Is(target error) bool { return target == e.Category() }
+
+// NetworkdConflictError is for when a networkd unit or dropin would be written to a path that
+// is already used by a file, directory or link in the config
+type NetworkdConflictError struct {
+	Path     string
+	Unit     string
+	File     string // path on the filesystem the unit or dropin would be written to
+	Existing string
+}
+
+func (e NetworkdConflictError) Error() string {
+	return fmt.Sprintf("Networkd unit %q cannot be written to %q, which conflicts with %s.", e.Unit, e.File, e.Existing)
+}
+
+func (e NetworkdConflictError) ConfigPath() string   { return e.Path }
+func (e NetworkdConflictError) Category() Category   { return ErrNetworkdConflict }
+func (e NetworkdConflictError) Is(target error) bool { return target == e.Category() }
+
+// Feature identifies a part of the spec 3 config that older spec versions
+// cannot represent
+type Feature string
+
+const (
+	FeatureCompression        Feature = "compression"
+	FeatureHTTPHeaders        Feature = "httpHeaders"
+	FeatureSha256             Feature = "sha256"
+	FeatureProxy              Feature = "proxy"
+	FeatureMountOptions       Feature = "mountOptions"
+	FeatureLuks               Feature = "luks"
+	FeatureShouldExist        Feature = "shouldExist"
+	FeatureResize             Feature = "resize"
+	FeatureKernelArguments    Feature = "kernelArguments"
+	FeatureTangAdvertisement  Feature = "tangAdvertisement"
+	FeatureLuksDiscard        Feature = "luksDiscard"
+	FeatureLuksOpenOptions    Feature = "luksOpenOptions"
+	FeatureSpecialModeBits    Feature = "specialModeBits"
+	FeatureArnSource          Feature = "arnSource"
+	FeatureCex                Feature = "cex"
+	FeatureWipePartitionEntry Feature = "wipePartitionEntry"
+)
+
+// UnsupportedFeatureError is for when a config uses a feature that the spec
+// version it is being translated to cannot represent
+type UnsupportedFeatureError struct {
+	// Path is the JSON path of the offending field, e.g.
+	// $.storage.files[3].contents.httpHeaders
+	Path    string
+	Feature Feature
+	// Version is the spec version being translated to, e.g. 2.2
+	Version string
+}
+
+func (e UnsupportedFeatureError) Error() string {
+	return fmt.Sprintf("%s: %s is not supported on %s", e.Path, e.Feature, e.Version)
+}
+
+func (e UnsupportedFeatureError) ConfigPath() string   { return e.Path }
+func (e UnsupportedFeatureError) Category() Category   { return ErrUnsupportedFeature }
+func (e UnsupportedFeatureError) Is(target error) bool { return target == e.Category() }
+
+// LostFieldError is for when a translator dropped a field without
+// rejecting or reporting it
+type LostFieldError struct {
+	// Path is the JSON path of the field, with [*] for slice indices
+	Path string
+	// Count is how many of the values of the field were lost
+	Count int
+}
+
+func (e LostFieldError) Error() string {
+	return fmt.Sprintf("%s: %d value(s) lost in translation", e.Path, e.Count)
+}
+
+func (e LostFieldError) ConfigPath() string   { return e.Path }
+func (e LostFieldError) Category() Category   { return ErrLostField }
+func (e LostFieldError) Is(target error) bool { return target == e.Category() }
+
+// Errors is a list of problems found in a config, so that all of them can be
+// reported at once rather than one per run. Problems are collected with Add
+// and returned with ErrorOrNil.
+type Errors []error
+
+// Add records err, flattening it if it is itself an Errors. A nil err is
+// ignored.
+func (e *Errors) Add(err error) {
+	if err == nil {
+		return
+	}
+	if errs, ok := err.(Errors); ok {
+		*e = append(*e, errs...)
+		return
+	}
+	*e = append(*e, err)
+}
+
+func (e Errors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the problems, for Go 1.20 and later.
+func (e Errors) Unwrap() []error {
+	return e
+}
+
+// Is allows errors.Is to match any of the problems. Go before 1.20 does not
+// unwrap lists of errors, so Unwrap alone would not do.
+func (e Errors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As allows errors.As to match any of the problems, setting target to the
+// first one that matches.
+func (e Errors) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorOrNil returns nil if no problems were recorded, the problem itself if
+// there was exactly one, and e otherwise.
+func (e Errors) ErrorOrNil() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
@@ -0,0 +1,178 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ReadFsMap reads a filesystem mapping file. See ParseFsMap for the
+// accepted formats.
+func ReadFsMap(fname string) (map[string]string, []string, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, nil, err
+	}
+	m, warnings, err := ParseFsMap(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return m, warnings, nil
+}
+
+// ParseFsMap parses a mapping from filesystem name to mountpoint and
+// validates it with ValidateFsMap. The mapping is either a JSON object of
+// name to path, plain text, with one whitespace separated name and absolute
+// path per line, blank lines and lines starting with # ignored, or a YAML
+// object of name to path, tried in that order. A filesystem with an empty
+// path (a line with only the name in the plain text format) has no
+// mountpoint.
+func ParseFsMap(data []byte) (map[string]string, []string, error) {
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		var textErr error
+		if m, textErr = parseTextFsMap(data); textErr != nil {
+			if err := yaml.Unmarshal(data, &m); err != nil {
+				if looksStructured(data) {
+					return nil, nil, err
+				}
+				return nil, nil, textErr
+			}
+		}
+	}
+	if m == nil {
+		m = map[string]string{}
+	}
+	warnings, err := ValidateFsMap(m)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m, warnings, nil
+}
+
+// looksStructured returns whether the first significant line of data looks
+// like JSON or YAML rather than the plain text format, to pick the error to
+// report when no format parses.
+func looksStructured(data []byte) bool {
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "{") || strings.HasPrefix(line, "---") {
+			return true
+		}
+		return strings.HasSuffix(strings.Fields(line)[0], ":")
+	}
+	return false
+}
+
+// parseTextFsMap parses the plain text format. Paths must be absolute, so
+// that YAML, whose values are not, is not mistaken for it.
+func parseTextFsMap(data []byte) (map[string]string, error) {
+	m := map[string]string{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Fields(line)
+		if len(parts) > 2 {
+			return nil, fmt.Errorf("line %d: expected a filesystem name and a path, got %q", i+1, line)
+		}
+		if _, ok := m[parts[0]]; ok {
+			return nil, fmt.Errorf("line %d: filesystem %q is mapped more than once", i+1, parts[0])
+		}
+		m[parts[0]] = ""
+		if len(parts) == 2 {
+			if !path.IsAbs(parts[1]) {
+				return nil, fmt.Errorf("line %d: filesystem %q is mapped to %q, which is not an absolute path", i+1, parts[0], parts[1])
+			}
+			m[parts[0]] = parts[1]
+		}
+	}
+	return m, nil
+}
+
+// ValidateFsMap checks that every filesystem is mapped to an absolute path
+// (or to "" for no mountpoint) and that no two filesystems are mapped to the
+// same path. Paths are cleaned in place. Filesystems mounted beneath another
+// mapped filesystem are allowed, but reported in the returned warnings.
+func ValidateFsMap(m map[string]string) ([]string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	byPath := map[string]string{}
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("filesystem mapping has an empty filesystem name")
+		}
+		p := m[name]
+		if p == "" {
+			continue
+		}
+		if !path.IsAbs(p) {
+			return nil, fmt.Errorf("filesystem %q is mapped to %q, which is not an absolute path", name, p)
+		}
+		p = path.Clean(p)
+		if other, ok := byPath[p]; ok {
+			return nil, fmt.Errorf("filesystems %q and %q are both mapped to %q", other, name, p)
+		}
+		byPath[p] = name
+		m[name] = p
+	}
+
+	var warnings []string
+	for _, name := range names {
+		for _, parent := range names {
+			p, pp := m[name], m[parent]
+			if name == parent || pp == "" || pp == "/" || !strings.HasPrefix(p, pp+"/") {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("filesystem %q (%s) is mounted beneath filesystem %q (%s)", name, p, parent, pp))
+		}
+	}
+	return warnings, nil
+}
+
+// FormatFsMap returns the mapping in the plain text format read by
+// ParseFsMap, sorted by filesystem name.
+func FormatFsMap(m map[string]string) []byte {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b bytes.Buffer
+	for _, name := range names {
+		if m[name] == "" {
+			fmt.Fprintf(&b, "%s\n", name)
+		} else {
+			fmt.Fprintf(&b, "%s %s\n", name, m[name])
+		}
+	}
+	return b.Bytes()
+}
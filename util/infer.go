@@ -0,0 +1,164 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"path"
+	"strings"
+
+	"github.com/coreos/go-systemd/v22/unit"
+)
+
+// FsMapSource describes where the path of a filesystem came from
+type FsMapSource string
+
+const (
+	// FsMapSourceGiven is for paths taken from the fsMap passed in
+	FsMapSourceGiven FsMapSource = "fsmap"
+	// FsMapSourceMountUnit is for paths taken from the Where= of a systemd
+	// mount unit in the config mounting the filesystem
+	FsMapSourceMountUnit FsMapSource = "mount unit"
+	// FsMapSourceLabel is for paths derived from a well-known filesystem label
+	FsMapSourceLabel FsMapSource = "label"
+)
+
+// WellKnownLabels maps the filesystem labels whose mountpoint can be assumed
+// to that mountpoint.
+var WellKnownLabels = map[string]string{
+	"var":        "/var",
+	"home":       "/home",
+	"containers": "/var/lib/containers",
+}
+
+// FsInfo is the part of a v2 filesystem used to infer its path
+type FsInfo struct {
+	Name   string
+	Device string
+	Label  string
+	UUID   string
+}
+
+// UnitInfo is the part of a systemd unit used to infer filesystem paths.
+// Contents holds the unit followed by its dropins.
+type UnitInfo struct {
+	Name     string
+	Contents []string
+}
+
+// InferredPath records the path of a filesystem and where it came from
+type InferredPath struct {
+	Filesystem string
+	Path       string
+	Source     FsMapSource
+	// Detail is the mount unit or label the path was inferred from
+	Detail string
+}
+
+// InferFsMap fills in the paths of filesystems missing from fsMap, first
+// from systemd mount units mounting the filesystem, then from
+// WellKnownLabels. A path already used by another filesystem is never
+// inferred. It returns the completed mapping, leaving fsMap untouched, and
+// the source of the path of every mapped filesystem.
+func InferFsMap(fsMap map[string]string, filesystems []FsInfo, units []UnitInfo) (map[string]string, []InferredPath) {
+	ret := map[string]string{}
+	used := map[string]bool{}
+	for name, p := range fsMap {
+		ret[name] = p
+		used[p] = true
+	}
+
+	mounts := mountUnits(units)
+	var report []InferredPath
+	for _, fs := range filesystems {
+		if fs.Name == "root" {
+			continue
+		}
+		if p, ok := fsMap[fs.Name]; ok {
+			report = append(report, InferredPath{Filesystem: fs.Name, Path: p, Source: FsMapSourceGiven})
+			continue
+		}
+		var inferred *InferredPath
+		for _, m := range mounts {
+			if mountsFilesystem(m.what, fs) && !used[m.where] {
+				inferred = &InferredPath{Filesystem: fs.Name, Path: m.where, Source: FsMapSourceMountUnit, Detail: m.name}
+				break
+			}
+		}
+		if p, ok := WellKnownLabels[fs.Label]; inferred == nil && ok && !used[p] {
+			inferred = &InferredPath{Filesystem: fs.Name, Path: p, Source: FsMapSourceLabel, Detail: fs.Label}
+		}
+		if inferred == nil {
+			continue
+		}
+		ret[fs.Name] = inferred.Path
+		used[inferred.Path] = true
+		report = append(report, *inferred)
+	}
+	return ret, report
+}
+
+type mountUnit struct {
+	name  string
+	what  string
+	where string
+}
+
+// mountUnits returns the What= and Where= of every mount unit that parses
+// and has both set, with later dropins overriding earlier settings.
+func mountUnits(units []UnitInfo) []mountUnit {
+	var ret []mountUnit
+	for _, u := range units {
+		if !strings.HasSuffix(u.Name, ".mount") {
+			continue
+		}
+		m := mountUnit{name: u.Name}
+		for _, contents := range u.Contents {
+			opts, err := unit.DeserializeOptions(strings.NewReader(contents))
+			if err != nil {
+				continue
+			}
+			for _, o := range opts {
+				if o.Section != "Mount" {
+					continue
+				}
+				switch o.Name {
+				case "What":
+					m.what = o.Value
+				case "Where":
+					m.where = o.Value
+				}
+			}
+		}
+		if m.what != "" && path.IsAbs(m.where) {
+			m.where = path.Clean(m.where)
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+// mountsFilesystem returns whether the What= of a mount unit refers to fs
+func mountsFilesystem(what string, fs FsInfo) bool {
+	if what == fs.Device {
+		return true
+	}
+	if fs.Label != "" && (what == "LABEL="+fs.Label || what == "/dev/disk/by-label/"+fs.Label) {
+		return true
+	}
+	if fs.UUID != "" && (what == "UUID="+fs.UUID || what == "/dev/disk/by-uuid/"+fs.UUID) {
+		return true
+	}
+	return false
+}
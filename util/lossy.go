@@ -0,0 +1,148 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Degradation describes a feature removed from or worked around in a config
+// so it could be translated to a spec version that cannot represent it
+type Degradation struct {
+	// Path is the JSON path of the field in the source config
+	Path    string  `json:"path"`
+	Feature Feature `json:"feature"`
+	// Action is ActionDrop or ActionWorkaround
+	Action Action `json:"action"`
+	// Version is the spec version being translated to
+	Version string `json:"version"`
+}
+
+func (d Degradation) String() string {
+	if d.Action == ActionWorkaround {
+		return fmt.Sprintf("%s: %s, as %s is not supported on %s", d.Path, workarounds[d.Feature], d.Feature, d.Version)
+	}
+	return fmt.Sprintf("%s: dropped %s, which is not supported on %s", d.Path, d.Feature, d.Version)
+}
+
+// FeatureCheck collects the features of a config that the spec version it
+// is being translated to cannot represent, and handles them as Policy says.
+// Problems other than unsupported features can be added to Errors directly.
+type FeatureCheck struct {
+	// Version is the spec version being translated to
+	Version  string
+	Policy   Policy
+	Errors   Errors
+	Degraded []Degradation
+}
+
+// Unsupported records that the feature at the JSON path is not supported.
+// If the policy drops the feature, drop is called to remove it from the
+// config and the removal is reported in c.Degraded, otherwise an
+// UnsupportedFeatureError is added to c.Errors.
+func (c *FeatureCheck) Unsupported(path string, feature Feature, drop func()) {
+	c.Workaround(path, feature, drop, nil)
+}
+
+// Workaround is like Unsupported for a feature with a workaround. If the
+// policy asks for it, workaround is called to apply it, and returns whether
+// it could; if it could not, the feature is unsupported after all.
+func (c *FeatureCheck) Workaround(path string, feature Feature, drop func(), workaround func() bool) {
+	action := c.Policy.Action(feature)
+	switch {
+	case action == ActionDrop:
+		drop()
+	case action == ActionWorkaround && workaround != nil && workaround():
+	default:
+		c.Errors.Add(UnsupportedFeatureError{Path: path, Feature: feature, Version: c.Version})
+		return
+	}
+	c.Degraded = append(c.Degraded, Degradation{Path: path, Feature: feature, Action: action, Version: c.Version})
+}
+
+// DeepCopy returns a copy of v that shares no pointers, slices or maps with
+// it, so the copy of a config can be modified without affecting the caller's.
+// v must not contain unexported fields.
+func DeepCopy(v interface{}) interface{} {
+	return deepCopy(reflect.ValueOf(v)).Interface()
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.New(v.Type().Elem())
+		ret.Elem().Set(deepCopy(v.Elem()))
+		return ret
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			ret.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return ret
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ret := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			ret.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return ret
+	case reflect.Struct:
+		ret := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			ret.Field(i).Set(deepCopy(v.Field(i)))
+		}
+		return ret
+	}
+	return v
+}
+
+// Retarget sets the Version of every entry of degraded to version, for
+// translators chaining others to reach an older spec version.
+func Retarget(degraded []Degradation, version string) []Degradation {
+	for i := range degraded {
+		degraded[i].Version = version
+	}
+	return degraded
+}
+
+// RetargetErrors is like Retarget for the UnsupportedFeatureErrors in err,
+// which may be an Errors. Other errors are kept as they are.
+func RetargetErrors(err error, version string) error {
+	var errs Errors
+	if list, ok := err.(Errors); ok {
+		errs = list
+	} else {
+		errs = Errors{err}
+	}
+	var ret Errors
+	for _, e := range errs {
+		if u, ok := e.(UnsupportedFeatureError); ok {
+			u.Version = version
+			e = u
+		}
+		ret.Add(e)
+	}
+	return ret.ErrorOrNil()
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Features lists every feature a translator to an older spec version can
+// report as unsupported.
+var Features = []Feature{
+	FeatureCompression,
+	FeatureHTTPHeaders,
+	FeatureSha256,
+	FeatureProxy,
+	FeatureMountOptions,
+	FeatureLuks,
+	FeatureShouldExist,
+	FeatureResize,
+	FeatureKernelArguments,
+	FeatureTangAdvertisement,
+	FeatureLuksDiscard,
+	FeatureLuksOpenOptions,
+	FeatureSpecialModeBits,
+	FeatureArnSource,
+	FeatureCex,
+	FeatureWipePartitionEntry,
+}
+
+// workarounds lists the features that have a workaround, and what it is
+var workarounds = map[Feature]string{
+	FeatureSpecialModeBits: "masked out the special mode bits",
+	FeatureHTTPHeaders:     "removed the headers of a data: URL",
+}
+
+// defaults lists the actions for features missing from a Policy other than
+// ActionFail. Translators to 2.2 dropped wipePartitionEntry before reporting
+// it, so they still drop it unless a Policy says to fail, keeping the configs
+// that translated before translatable.
+var defaults = map[Feature]Action{
+	FeatureWipePartitionEntry: ActionDrop,
+}
+
+// Action is what a translator does with a feature the spec version it
+// translates to cannot represent.
+type Action string
+
+const (
+	// ActionFail fails the translation
+	ActionFail Action = "fail"
+	// ActionDrop removes the feature from the config
+	ActionDrop Action = "drop"
+	// ActionWorkaround keeps what it can of the feature where the feature
+	// has a workaround, and fails the translation otherwise
+	ActionWorkaround Action = "workaround"
+)
+
+// Policy maps features to the action taken when a translator finds them in
+// a config. Features missing from a Policy fail the translation, except for
+// wipePartitionEntry, which is dropped. The zero Policy is the behavior of
+// Translate.
+type Policy map[Feature]Action
+
+// DropPolicy returns a Policy that drops every feature.
+func DropPolicy() Policy {
+	p := Policy{}
+	for _, f := range Features {
+		p[f] = ActionDrop
+	}
+	return p
+}
+
+// Action returns the action to take for feature.
+func (p Policy) Action(feature Feature) Action {
+	if a, ok := p[feature]; ok {
+		return a
+	}
+	if a, ok := defaults[feature]; ok {
+		return a
+	}
+	return ActionFail
+}
+
+// IsStrict returns whether p fails the translation for every feature.
+func (p Policy) IsStrict() bool {
+	for _, f := range Features {
+		if p.Action(f) != ActionFail {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadPolicy reads a policy file. See ParsePolicy for the format.
+func ReadPolicy(fname string) (Policy, error) {
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	p, err := ParsePolicy(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", fname, err)
+	}
+	return p, nil
+}
+
+// ParsePolicy parses a JSON or YAML object of feature to action and
+// validates it with ValidatePolicy, e.g.
+//
+//	specialModeBits: workaround
+//	kernelArguments: drop
+func ParsePolicy(data []byte) (Policy, error) {
+	var p Policy
+	if err := yaml.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if p == nil {
+		p = Policy{}
+	}
+	if err := ValidatePolicy(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ValidatePolicy checks that every feature of p is known, and that every
+// action is known and, for ActionWorkaround, available for the feature.
+func ValidatePolicy(p Policy) error {
+	features := make([]string, 0, len(p))
+	for f := range p {
+		features = append(features, string(f))
+	}
+	sort.Strings(features)
+	for _, name := range features {
+		f := Feature(name)
+		if !isFeature(f) {
+			return fmt.Errorf("unknown feature %q", f)
+		}
+		switch p[f] {
+		case ActionFail, ActionDrop:
+		case ActionWorkaround:
+			if _, ok := workarounds[f]; !ok {
+				return fmt.Errorf("feature %q has no workaround", f)
+			}
+		default:
+			return fmt.Errorf("unknown action %q for feature %q, must be %q, %q or %q", p[f], f, ActionFail, ActionDrop, ActionWorkaround)
+		}
+	}
+	return nil
+}
+
+func isFeature(feature Feature) bool {
+	for _, f := range Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
@@ -15,74 +15,131 @@
 package util
 
 import (
-	"errors"
 	"fmt"
+	"path"
 	"strings"
 )
 
-// Error definitions
+// NetworkdDir is the directory networkd units are read from
+const NetworkdDir = "/etc/systemd/network"
 
-// UsesNetworkdError is the error for including networkd configs
-var UsesNetworkdError = errors.New("config includes deprecated networkd section - use Files instead")
-
-// NoFilesystemError type for when a filesystem is referenced in a config but there's no mapping to where
-// it should be mounted (i.e. `path` in v3+ configs)
-type NoFilesystemError string
-
-func (e NoFilesystemError) Error() string {
-	return fmt.Sprintf("Config defined filesystem %q but no mapping was defined."+
-		"Please specify a path to be used as the filesystem mountpoint.", string(e))
-}
-
-// DuplicateInodeError is for when files, directories, or links both specify the same path
-type DuplicateInodeError struct {
-	Old string // first occurance of the path
-	New string // second occurance of the path
-}
-
-func (e DuplicateInodeError) Error() string {
-	return fmt.Sprintf("Config has conflicting inodes: %q and %q.  All files, directories and links must specify a unique `path`.", e.Old, e.New)
-}
-
-// UsesOwnLinkError is for when files, directories, or links use symlinks defined in the config
-// in their own path. This is disallowed in v3+ configs.
-type UsesOwnLinkError struct {
-	LinkPath string
-	Name     string
-}
-
-func (e UsesOwnLinkError) Error() string {
-	return fmt.Sprintf("%s uses link defined in config %q. Please use a link not defined in Storage:Links", e.Name, e.LinkPath)
+// IsNetworkdUnit returns whether name has the extension of a networkd unit
+func IsNetworkdUnit(name string) bool {
+	switch path.Ext(name) {
+	case ".link", ".netdev", ".network":
+		return true
+	}
+	return false
 }
 
-// DuplicateUnitError is for when a unit name is used twice
-type DuplicateUnitError struct {
-	Name string
+// Rewrite describes a change made to a config while translating it
+type Rewrite struct {
+	// Path is the JSON path of the rewritten field, e.g. $.passwd.users[0].create
+	Path    string
+	Message string
 }
 
-func (e DuplicateUnitError) Error() string {
-	return fmt.Sprintf("Config has duplicate unit name %q.  All units must specify a unique `name`.", e.Name)
+func (r Rewrite) String() string {
+	return fmt.Sprintf("%s: %s", r.Path, r.Message)
 }
 
-// DuplicateDropinError is for when a unit has multiple dropins with the same name
-type DuplicateDropinError struct {
-	Unit string
-	Name string
-}
+// DefaultSectorSize is the logical sector size assumed when none is given
+const DefaultSectorSize = 512
 
-func (e DuplicateDropinError) Error() string {
-	return fmt.Sprintf("Config has duplicate dropin name %q in unit %q.  All dropins must specify a unique `name`.", e.Name, e.Unit)
+// CheckSectorSize returns the logical sector size to use for sectorSize,
+// which must be 512, 4096 or 0 for DefaultSectorSize.
+func CheckSectorSize(sectorSize int) (int, error) {
+	switch sectorSize {
+	case 0:
+		return DefaultSectorSize, nil
+	case 512, 4096:
+		return sectorSize, nil
+	}
+	return 0, fmt.Errorf("unsupported logical sector size %d, must be 512 or 4096", sectorSize)
 }
 
+// CheckPathUsesLink returns the first of links that path goes through, or
+// "" if there is none. Only whole path components match, so a link /opt/bin
+// is not used by /opt/binary.
 func CheckPathUsesLink(links []string, path string) string {
 	for _, l := range links {
-		if strings.HasPrefix(path, l) && path != l {
+		if strings.HasPrefix(path, l+"/") {
 			return l
 		}
 	}
 	return ""
 }
 
+// maxLinkHops is how many links ResolveLinks follows before giving up, like
+// the kernel's limit on nested symlinks that makes it return ELOOP
+const maxLinkHops = 40
+
+// ResolveLinks rewrites p to not go through any of links, a map from the
+// path of a symlink to its target. Targets are resolved relative to the
+// directory of the link and may go through other links in turn. p itself is
+// not resolved, only its parent directories.
+func ResolveLinks(p string, links map[string]string) (string, error) {
+	seen := map[string]bool{p: true}
+	for hops := 0; ; hops++ {
+		// the kernel resolves the outermost link first
+		l := ""
+		for dir := path.Dir(p); dir != "/" && dir != "."; dir = path.Dir(dir) {
+			if _, ok := links[dir]; ok {
+				l = dir
+			}
+		}
+		if l == "" {
+			return p, nil
+		}
+		// a link pointing beneath itself never repeats a path
+		if hops == maxLinkHops {
+			return "", LinkCycleError{File: p}
+		}
+		target := links[l]
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(l), target)
+		}
+		p = path.Join(target, strings.TrimPrefix(p, l))
+		if seen[p] {
+			return "", LinkCycleError{File: p}
+		}
+		seen[p] = true
+	}
+}
+
+// ResolveFilesystem returns which of the filesystem mountpoints in fss the
+// path p is on, and p relative to that mountpoint. Paths are compared by
+// whole components, ignoring trailing slashes, so /variable is not on /var,
+// and the deepest mountpoint wins, so /var/lib/containers/x is on
+// /var/lib/containers rather than /var. A path on none of fss is returned
+// unchanged on the "root" filesystem.
+func ResolveFilesystem(p string, fss []string) (string, string) {
+	fsname := "root"
+	rel := p
+	depth := -1
+	clean := path.Clean(p)
+	for _, fs := range fss {
+		mnt := path.Clean(fs)
+		var r string
+		switch {
+		case mnt == "/":
+			r = clean
+		case clean == mnt:
+			r = "/"
+		case strings.HasPrefix(clean, mnt+"/"):
+			r = strings.TrimPrefix(clean, mnt)
+		default:
+			continue
+		}
+		if d := strings.Count(strings.TrimSuffix(mnt, "/"), "/"); d > depth {
+			fsname = fs
+			rel = r
+			depth = d
+		}
+	}
+	return fsname, rel
+}
+
 func StrP(in string) *string {
 	if in == "" {
 		return nil
@@ -112,6 +169,10 @@ func IntP(in int) *int {
 	return &in
 }
 
+func IntPStrict(in int) *int {
+	return &in
+}
+
 func StrV(in *string) string {
 	if in == nil {
 		return ""
@@ -132,3 +193,9 @@ func IntV(in *int) int {
 	}
 	return *in
 }
+
+// IsDataURL returns whether source is a data: URL, which is fetched without
+// a request and so ignores HTTP headers
+func IsDataURL(source *string) bool {
+	return source != nil && strings.HasPrefix(*source, "data:")
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Red Hat, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Walk calls visit for v, which must be addressable, and for every field,
+// slice element and pointer target beneath it, along with its JSON path
+// relative to path. It lets translators find the fields an older spec
+// version cannot represent wherever they are nested in a config.
+//
+// If visit returns false, the value is not descended into and is removed
+// from the config: slice elements are removed from the slice, pointers to
+// the value are set to nil, and other values are set to their zero value.
+func Walk(v reflect.Value, path string, visit func(v reflect.Value, path string) bool) {
+	if !visit(v, path) {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	descend(v, path, visit)
+}
+
+func descend(v reflect.Value, path string, visit func(v reflect.Value, path string) bool) {
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			Walk(v.Field(i), fieldPath(v.Type().Field(i), path), visit)
+		}
+	case reflect.Slice:
+		kept := reflect.MakeSlice(v.Type(), 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			elem := v.Index(i)
+			elemPath := fmt.Sprintf("%s[%d]", path, i)
+			if visit(elem, elemPath) {
+				descend(elem, elemPath, visit)
+				kept = reflect.Append(kept, elem)
+			}
+		}
+		if kept.Len() < v.Len() {
+			v.Set(kept)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		if !visit(v.Elem(), path) {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		descend(v.Elem(), path, visit)
+	}
+}
+
+// fieldPath returns the JSON path of field f of the struct at path. The
+// fields of embedded structs are serialized as fields of the outer struct.
+func fieldPath(f reflect.StructField, path string) string {
+	if f.Anonymous {
+		return path
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		name = f.Name
+	}
+	return path + "." + name
+}